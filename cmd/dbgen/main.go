@@ -0,0 +1,68 @@
+// 根据数据库表结构生成模型代码
+// 用法：dbgen -driver mysql -host 127.0.0.1 -user root -password xxx -db test -out ./model
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go_lib/database"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	conf := &database.DBConfig{}
+	flag.StringVar(&conf.DBDriver, "driver", "mysql", "数据库类型，目前只支持mysql")
+	flag.StringVar(&conf.DBHost, "host", "127.0.0.1", "数据库地址")
+	flag.StringVar(&conf.DBPort, "port", "3306", "数据库端口")
+	flag.StringVar(&conf.DBUser, "user", "root", "用户名")
+	flag.StringVar(&conf.DBPassword, "password", "", "密码")
+	flag.StringVar(&conf.DBName, "db", "", "数据库名")
+	schema := flag.String("schema", "", "表所在的schema，默认为数据库名")
+	tables := flag.String("tables", "", "需要生成的表，多个用逗号分隔，为空时生成全部表")
+	opts := &database.GenOptions{}
+	flag.StringVar(&opts.Package, "pkg", "model", "生成代码的包名")
+	flag.StringVar(&opts.NullStyle, "null", database.GenNullPointer, "可空字段的类型：pointer、sql")
+	flag.StringVar(&opts.TrimPrefix, "trim-prefix", "", "生成结构体名时去掉的表名前缀")
+	out := flag.String("out", ".", "输出目录")
+	flag.Parse()
+
+	if conf.DBName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// 只引入了MySQL的驱动
+	if conf.DBDriver != "mysql" {
+		log.Fatalf("dbgen: unsupported driver %s", conf.DBDriver)
+	}
+	if *schema == "" {
+		*schema = conf.DBName
+	}
+	var names []string
+	for _, name := range strings.Split(*tables, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	db, err := database.NewSqlDB(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	list, err := db.LoadSchema(*schema, names...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+	files, err := database.GenerateFiles(list, *out, opts)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
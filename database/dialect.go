@@ -0,0 +1,389 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 数据库方言
+// 屏蔽不同数据库在字段转义、占位符、分页、自增ID及插入更新语法上的差异
+type Dialect interface {
+	// 方言名称
+	Name() string
+	// database/sql注册的驱动名称
+	DriverName() string
+	// 生成连接字符串
+	Dsn(conf *DBConfig) string
+	// 转义表名、字段名
+	Quote(name string) string
+	// 第index个占位符，从1开始
+	Placeholder(index int) string
+	// 分页语句，offset为0时可省略偏移量
+	Limit(size int, offset int) string
+	// 新增时获取自增ID的子句，返回空字符串表示使用sql.Result.LastInsertId
+	Returning(pk string) string
+	// 冲突时引用待插入数据的字段
+	Excluded(column string) string
+	// 冲突时的处理子句，sets为空表示忽略冲突
+	OnConflict(keys []string, sets []string) string
+	// 单条语句允许的最大占位符数量
+	MaxPlaceholders() int
+	// 根据批量新增返回的LastInsertId计算第一条记录的ID
+	FirstInsertId(lastId int64, rows int) int64
+	// 插入更新时用于判断是否为新增的RETURNING子句，返回空字符串表示使用受影响行数判断
+	UpsertReturning() string
+	// 根据插入更新的受影响行数判断执行结果
+	UpsertState(affected int64) UpsertState
+	// 正则匹配操作符，not为true时返回不匹配的操作符
+	Regexp(not bool) string
+	// JSON包含条件，参数使用?占位
+	JSONContains(column string) string
+	// 获取JSON路径对应的文本值，path如 a.b[0]
+	JSONPath(column string, path string) string
+	// DDL语句是否可以在事务中回滚
+	TransactionalDDL() bool
+}
+
+// 已注册的方言
+var dialects = map[string]Dialect{
+	"mysql":      MysqlDialect{},
+	"postgres":   PostgresDialect{},
+	"postgresql": PostgresDialect{},
+	"sqlite":     SqliteDialect{},
+	"sqlite3":    SqliteDialect{},
+}
+
+// 注册方言
+func RegisterDialect(name string, d Dialect) {
+	dialects[strings.ToLower(name)] = d
+}
+
+// 获取方言，名称为空时默认MySQL
+func GetDialect(name string) (Dialect, error) {
+	if name == "" {
+		return MysqlDialect{}, nil
+	}
+	d, ok := dialects[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown sql dialect: %s", name)
+	}
+	return d, nil
+}
+
+// 将SQL中的?占位符转换为方言的占位符，跳过引号中的内容
+func rebind(d Dialect, sqlStr string) string {
+	if d == nil || d.Placeholder(1) == "?" || strings.IndexByte(sqlStr, '?') == -1 {
+		return sqlStr
+	}
+	var buf strings.Builder
+	var quote byte
+	index := 0
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			index++
+			buf.WriteString(d.Placeholder(index))
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// 生成冲突键的引用列表
+func quoteColumns(d Dialect, columns []string) []string {
+	tmp := make([]string, 0, len(columns))
+	for _, c := range columns {
+		tmp = append(tmp, d.Quote(c))
+	}
+	return tmp
+}
+
+// MySQL方言
+type MysqlDialect struct{}
+
+func (MysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (MysqlDialect) DriverName() string {
+	return "mysql"
+}
+
+func (MysqlDialect) Dsn(conf *DBConfig) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
+}
+
+func (MysqlDialect) Quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (MysqlDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (MysqlDialect) Limit(size int, offset int) string {
+	if offset == 0 {
+		return fmt.Sprintf("LIMIT %d", size)
+	}
+	return fmt.Sprintf("LIMIT %d,%d", offset, size)
+}
+
+func (MysqlDialect) Returning(pk string) string {
+	return ""
+}
+
+func (d MysqlDialect) Excluded(column string) string {
+	return fmt.Sprintf("VALUES(%s)", d.Quote(column))
+}
+
+func (d MysqlDialect) OnConflict(keys []string, sets []string) string {
+	if len(sets) == 0 {
+		// MySQL没有DO NOTHING，用自我赋值忽略冲突，避免INSERT IGNORE吞掉其他错误
+		if len(keys) == 0 {
+			return ""
+		}
+		key := d.Quote(keys[0])
+		return fmt.Sprintf("ON DUPLICATE KEY UPDATE %s = %s", key, key)
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+}
+
+func (MysqlDialect) MaxPlaceholders() int {
+	return 65535
+}
+
+// MySQL批量新增时LastInsertId为第一条记录的ID
+func (MysqlDialect) FirstInsertId(lastId int64, rows int) int64 {
+	return lastId
+}
+
+func (MysqlDialect) UpsertReturning() string {
+	return ""
+}
+
+// MySQL新增时受影响行数为1，更新为2，数据未变化为0
+func (MysqlDialect) UpsertState(affected int64) UpsertState {
+	switch affected {
+	case 1:
+		return UpsertInserted
+	case 2:
+		return UpsertUpdated
+	}
+	return UpsertNone
+}
+
+func (MysqlDialect) Regexp(not bool) string {
+	if not {
+		return "NOT REGEXP"
+	}
+	return "REGEXP"
+}
+
+func (MysqlDialect) JSONContains(column string) string {
+	return fmt.Sprintf("JSON_CONTAINS(%s, ?)", column)
+}
+
+func (MysqlDialect) JSONPath(column string, path string) string {
+	return fmt.Sprintf("%s->>'$.%s'", column, path)
+}
+
+func (MysqlDialect) TransactionalDDL() bool {
+	return false
+}
+
+// PostgreSQL方言
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string {
+	return "postgres"
+}
+
+func (PostgresDialect) DriverName() string {
+	return "postgres"
+}
+
+func (PostgresDialect) Dsn(conf *DBConfig) string {
+	u := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.DBUser, conf.DBPassword),
+		Host:     conf.DBHost + ":" + conf.DBPort,
+		Path:     "/" + conf.DBName,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+func (PostgresDialect) Quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (PostgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (PostgresDialect) Limit(size int, offset int) string {
+	if offset == 0 {
+		return fmt.Sprintf("LIMIT %d", size)
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+
+func (d PostgresDialect) Returning(pk string) string {
+	if pk == "" {
+		return ""
+	}
+	return "RETURNING " + d.Quote(pk)
+}
+
+func (d PostgresDialect) Excluded(column string) string {
+	return "EXCLUDED." + d.Quote(column)
+}
+
+func (d PostgresDialect) OnConflict(keys []string, sets []string) string {
+	target := ""
+	if len(keys) > 0 {
+		target = fmt.Sprintf("(%s) ", strings.Join(quoteColumns(d, keys), ","))
+	}
+	if len(sets) == 0 {
+		return fmt.Sprintf("ON CONFLICT %sDO NOTHING", target)
+	}
+	return fmt.Sprintf("ON CONFLICT %sDO UPDATE SET %s", target, strings.Join(sets, ","))
+}
+
+func (PostgresDialect) MaxPlaceholders() int {
+	return 65535
+}
+
+// PostgreSQL通过RETURNING获取ID，不使用LastInsertId
+func (PostgresDialect) FirstInsertId(lastId int64, rows int) int64 {
+	return lastId
+}
+
+// 新增的记录xmax为0
+func (PostgresDialect) UpsertReturning() string {
+	return "RETURNING (xmax = 0)"
+}
+
+func (PostgresDialect) UpsertState(affected int64) UpsertState {
+	if affected > 0 {
+		return UpsertAffected
+	}
+	return UpsertNone
+}
+
+func (PostgresDialect) Regexp(not bool) string {
+	if not {
+		return "!~"
+	}
+	return "~"
+}
+
+func (PostgresDialect) JSONContains(column string) string {
+	return fmt.Sprintf("%s @> CAST(? AS jsonb)", column)
+}
+
+// a.b[0] => #>>'{a,b,0}'
+func (PostgresDialect) JSONPath(column string, path string) string {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	return fmt.Sprintf("%s#>>'{%s}'", column, strings.Replace(path, ".", ",", -1))
+}
+
+func (PostgresDialect) TransactionalDDL() bool {
+	return true
+}
+
+// SQLite方言
+type SqliteDialect struct{}
+
+func (SqliteDialect) Name() string {
+	return "sqlite3"
+}
+
+func (SqliteDialect) DriverName() string {
+	return "sqlite3"
+}
+
+// SQLite使用DBName作为数据库文件路径
+func (SqliteDialect) Dsn(conf *DBConfig) string {
+	return conf.DBName
+}
+
+func (SqliteDialect) Quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (SqliteDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (SqliteDialect) Limit(size int, offset int) string {
+	if offset == 0 {
+		return fmt.Sprintf("LIMIT %d", size)
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+
+func (SqliteDialect) Returning(pk string) string {
+	return ""
+}
+
+func (d SqliteDialect) Excluded(column string) string {
+	return "excluded." + d.Quote(column)
+}
+
+func (d SqliteDialect) OnConflict(keys []string, sets []string) string {
+	return PostgresDialect{}.OnConflict(keys, sets)
+}
+
+// 旧版本SQLite默认最多999个占位符
+func (SqliteDialect) MaxPlaceholders() int {
+	return 999
+}
+
+// SQLite批量新增时LastInsertId为最后一条记录的ID
+func (SqliteDialect) FirstInsertId(lastId int64, rows int) int64 {
+	return lastId - int64(rows) + 1
+}
+
+func (SqliteDialect) UpsertReturning() string {
+	return ""
+}
+
+// SQLite新增和更新的受影响行数都为1，无法区分
+func (SqliteDialect) UpsertState(affected int64) UpsertState {
+	if affected > 0 {
+		return UpsertAffected
+	}
+	return UpsertNone
+}
+
+// SQLite需要注册regexp函数才能使用
+func (SqliteDialect) Regexp(not bool) string {
+	if not {
+		return "NOT REGEXP"
+	}
+	return "REGEXP"
+}
+
+// SQLite没有JSON包含函数，判断JSON数组中是否存在该值
+func (SqliteDialect) JSONContains(column string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE json_each.value = json_extract(?, '$'))", column)
+}
+
+func (SqliteDialect) JSONPath(column string, path string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, path)
+}
+
+func (SqliteDialect) TransactionalDDL() bool {
+	return true
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 测试占位符转换
+func TestRebind(t *testing.T) {
+	sqlStr := "SELECT * FROM t WHERE a = ? AND b = '?' AND c IN (?,?)"
+	if res := rebind(MysqlDialect{}, sqlStr); res != sqlStr {
+		t.Errorf("mysql rebind changed sql: %s", res)
+	}
+	res := rebind(PostgresDialect{}, sqlStr)
+	if res != "SELECT * FROM t WHERE a = $1 AND b = '?' AND c IN ($2,$3)" {
+		t.Errorf("postgres rebind: %s", res)
+	}
+}
+
+// 测试不同方言生成的查询语句
+func TestDialectQuery(t *testing.T) {
+	tests := map[string]string{
+		"mysql":    "SELECT * FROM `t_user`  WHERE (`t_user`.`id` = ?)  LIMIT 10,10",
+		"postgres": `SELECT * FROM "t_user"  WHERE ("t_user"."id" = $1)  LIMIT 10 OFFSET 10`,
+		"sqlite3":  `SELECT * FROM "t_user"  WHERE ("t_user"."id" = ?)  LIMIT 10 OFFSET 10`,
+	}
+	for name, expect := range tests {
+		d, err := GetDialect(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := &SqlDB{dialect: d}
+		q := db.Table("t_user").Where(utils.M{"id": 1}, "").Limit(10, 2).Query()
+		if res := rebind(d, q.sqlStr); res != expect {
+			t.Errorf("%s: %s", name, res)
+		}
+	}
+}
+
+// 测试冲突处理语句
+func TestDialectOnConflict(t *testing.T) {
+	sets := []string{"`name` = " + MysqlDialect{}.Excluded("name")}
+	if res := (MysqlDialect{}).OnConflict([]string{"id"}, sets); res != "ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)" {
+		t.Errorf("mysql: %s", res)
+	}
+	if res := (PostgresDialect{}).OnConflict([]string{"id"}, nil); res != `ON CONFLICT ("id") DO NOTHING` {
+		t.Errorf("postgres: %s", res)
+	}
+	if res := (PostgresDialect{}).Dsn(&DBConfig{DBUser: "u", DBPassword: "p", DBHost: "h", DBPort: "5432", DBName: "d"}); !strings.HasPrefix(res, "postgres://u:p@h:5432/d") {
+		t.Errorf("postgres dsn: %s", res)
+	}
+	if _, err := GetDialect("oracle"); err == nil {
+		t.Error("unknown dialect should return error")
+	}
+}
+
+// 测试Postgres新增时按表的自增主键返回ID
+func TestPostgresInsertReturning(t *testing.T) {
+	db, fake := newFakeSqlDB("pg_returning", PostgresDialect{})
+
+	fake.setRows([]string{"id"}, []driver.Value{int64(5)})
+	if id, ok := db.Insert("t_user", utils.M{"name": "a"}); !ok || id != 5 {
+		t.Fatalf("unexpected id %d: %v", id, db.GetLastError())
+	}
+	db.RegisterPrimaryKey("t_order", "order_id")
+	fake.setRows([]string{"order_id"}, []driver.Value{int64(6)})
+	if id, ok := db.Table("t_order").Insert(utils.M{"name": "b"}); !ok || id != 6 {
+		t.Fatalf("unexpected id %d: %v", id, db.GetLastError())
+	}
+	// 没有自增主键的表不使用RETURNING
+	db.RegisterPrimaryKey("t_log", "")
+	if _, ok := db.Insert("t_log", utils.M{"name": "c"}); !ok {
+		t.Fatal(db.GetLastError())
+	}
+	expect := []string{
+		`INSERT INTO "t_user"("name") VALUES($1) RETURNING "id"`,
+		`INSERT INTO "t_order"("name") VALUES($1) RETURNING "order_id"`,
+		`INSERT INTO "t_log"("name") VALUES($1)`,
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+}
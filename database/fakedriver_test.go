@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"go_lib/database/internal/fakedb"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 测试用的数据库，记录执行的SQL并返回预设的结果
+type fakeDB struct {
+	mu       sync.Mutex
+	queries  []string
+	args     [][]driver.Value
+	columns  []string
+	types    []string
+	rows     [][]driver.Value
+	lastId   int64
+	changed  int64
+	prepared int  // 预处理语句的数量
+	down     bool // 模拟连接断开
+	open     int  // 未关闭的查询结果数量
+}
+
+// 新建测试数据库
+func newFakeSqlDB(name string, dialect Dialect) (*SqlDB, *fakeDB) {
+	fake := &fakeDB{lastId: 1, changed: 1}
+	fakedb.Register(name, fake)
+	db, _ := sql.Open(fakedb.DriverName, name)
+	return &SqlDB{db: db, dialect: dialect}, fake
+}
+
+// 设置查询返回的数据
+func (f *fakeDB) setRows(columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.columns = columns
+	f.types = nil
+	f.rows = rows
+}
+
+// 设置字段的数据库类型
+func (f *fakeDB) setTypes(types ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.types = types
+}
+
+// 获取执行过的SQL
+func (f *fakeDB) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.queries...)
+}
+
+// 记录SQL
+func (f *fakeDB) record(query string, args []driver.NamedValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]driver.Value, 0, len(args))
+	for _, a := range args {
+		values = append(values, a.Value)
+	}
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, values)
+}
+
+// 包含SLEEP的语句会一直等待到上下文结束
+func (f *fakeDB) wait(ctx context.Context, query string) error {
+	if !strings.Contains(query, "SLEEP") {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Second * 5):
+		return nil
+	}
+}
+
+// 语句或参数中包含FAIL时返回错误
+func (f *fakeDB) fail(query string, args []driver.NamedValue) bool {
+	if strings.Contains(query, "FAIL") {
+		return true
+	}
+	for _, a := range args {
+		if a.Value == "FAIL" {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) Prepare(query string) error {
+	f.mu.Lock()
+	f.prepared++
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeDB) Exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	f.record(query, args)
+	if err := f.wait(ctx, query); err != nil {
+		return nil, err
+	}
+	if f.fail(query, args) {
+		return nil, errors.New("fakedb: exec failed")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakedb.Result{LastId: f.lastId, Changed: f.changed}, nil
+}
+
+func (f *fakeDB) Query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		return nil, driver.ErrBadConn
+	}
+	f.record(query, args)
+	if err := f.wait(ctx, query); err != nil {
+		return nil, err
+	}
+	if f.fail(query, args) {
+		return nil, errors.New("fakedb: query failed")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open++
+	return &fakedb.Rows{Cols: f.columns, Types: f.types, Data: f.rows, OnClose: func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.open--
+	}}, nil
+}
+
+func (f *fakeDB) Begin() error {
+	f.record("BEGIN", nil)
+	return nil
+}
+
+func (f *fakeDB) Commit() error {
+	f.record("COMMIT", nil)
+	return nil
+}
+
+func (f *fakeDB) Rollback() error {
+	f.record("ROLLBACK", nil)
+	return nil
+}
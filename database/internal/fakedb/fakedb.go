@@ -0,0 +1,173 @@
+// 测试用的数据库驱动，由各包的测试实现Handler模拟数据库的行为
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// 驱动名
+const DriverName = "fakedb"
+
+// 模拟数据库的行为
+type Handler interface {
+	Prepare(query string) error
+	Exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error)
+	Query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error)
+	Begin() error
+	Commit() error
+	Rollback() error
+}
+
+var (
+	handlers = make(map[string]Handler)
+	lock     sync.Mutex
+	once     sync.Once
+)
+
+// 注册测试数据库，name为打开连接时的dsn
+func Register(name string, h Handler) {
+	once.Do(func() {
+		sql.Register(DriverName, fakeDriver{})
+	})
+	lock.Lock()
+	handlers[name] = h
+	lock.Unlock()
+}
+
+// 执行结果
+type Result struct {
+	LastId  int64
+	Changed int64
+}
+
+func (r Result) LastInsertId() (int64, error) {
+	return r.LastId, nil
+}
+
+func (r Result) RowsAffected() (int64, error) {
+	return r.Changed, nil
+}
+
+// 查询结果
+type Rows struct {
+	Cols    []string
+	Types   []string // 字段的数据库类型
+	Data    [][]driver.Value
+	OnClose func()
+	index   int
+}
+
+func (r *Rows) Columns() []string {
+	return r.Cols
+}
+
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.Types) {
+		return r.Types[index]
+	}
+	return ""
+}
+
+func (r *Rows) Close() error {
+	if r.OnClose != nil {
+		r.OnClose()
+	}
+	return nil
+}
+
+func (r *Rows) Next(dest []driver.Value) error {
+	if r.index >= len(r.Data) {
+		return io.EOF
+	}
+	copy(dest, r.Data[r.index])
+	r.index++
+	return nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	h, ok := handlers[name]
+	if !ok {
+		return nil, errors.New("fakedb: unknown database " + name)
+	}
+	return &fakeConn{h: h}, nil
+}
+
+type fakeConn struct {
+	h Handler
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if err := c.h.Prepare(query); err != nil {
+		return nil, err
+	}
+	return &fakeStmt{h: c.h, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if err := c.h.Begin(); err != nil {
+		return nil, err
+	}
+	return &fakeTx{h: c.h}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.h.Exec(ctx, query, args)
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.h.Query(ctx, query, args)
+}
+
+type fakeStmt struct {
+	h     Handler
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.h.Exec(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.h.Query(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+	for i, v := range args {
+		named = append(named, driver.NamedValue{Ordinal: i + 1, Value: v})
+	}
+	return named
+}
+
+type fakeTx struct {
+	h Handler
+}
+
+func (t *fakeTx) Commit() error {
+	return t.h.Commit()
+}
+
+func (t *fakeTx) Rollback() error {
+	return t.h.Rollback()
+}
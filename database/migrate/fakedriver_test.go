@@ -0,0 +1,196 @@
+package migrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"go_lib/database"
+	"go_lib/database/internal/fakedb"
+	"strings"
+	"sync"
+)
+
+// 用于测试的数据库，模拟版本记录表及锁表
+type fakeDB struct {
+	mu       sync.Mutex
+	versions map[int64]int64 // 已执行的版本及执行时间
+	locked   bool
+	owner    string
+	lockedAt int64
+	lockErr  error    // 获取锁时返回的错误
+	execs    []string // 执行过的迁移语句
+	txs      []string // 事务的开始、提交及回滚
+	onExec   func(query string)
+	snapshot *fakeDB // 事务开始时的数据
+}
+
+// 没有记录被修改
+var errNoRows = errors.New("no rows affected")
+
+var fakeOnce sync.Once
+
+// 测试使用的方言，DDL是否支持事务可配置
+type fakeDialect struct {
+	database.SqliteDialect
+	ddl bool
+}
+
+func (fakeDialect) DriverName() string {
+	return fakedb.DriverName
+}
+
+func (d fakeDialect) TransactionalDDL() bool {
+	return d.ddl
+}
+
+// 新建测试数据库，ddl为DDL是否支持事务
+func newFakeDB(name string, ddl bool) (*database.SqlDB, *fakeDB) {
+	fakeOnce.Do(func() {
+		database.RegisterDialect("migratefake", fakeDialect{})
+		database.RegisterDialect("migratefake_ddl", fakeDialect{ddl: true})
+	})
+	fake := &fakeDB{versions: make(map[int64]int64)}
+	fakedb.Register(name, fake)
+	driverName := "migratefake"
+	if ddl {
+		driverName = "migratefake_ddl"
+	}
+	db, err := database.NewSqlDB(&database.DBConfig{DBDriver: driverName, DBName: name})
+	if err != nil {
+		panic(err)
+	}
+	return db, fake
+}
+
+// 获取执行过的迁移语句
+func (f *fakeDB) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.execs...)
+}
+
+// 获取已执行的版本
+func (f *fakeDB) applied() map[int64]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	applied := make(map[int64]bool, len(f.versions))
+	for v := range f.versions {
+		applied[v] = true
+	}
+	return applied
+}
+
+func (f *fakeDB) isLocked() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.locked
+}
+
+// 执行语句
+func (f *fakeDB) exec(query string, args []driver.NamedValue) error {
+	f.mu.Lock()
+	onExec := f.onExec
+	defer func() {
+		f.mu.Unlock()
+		if onExec != nil {
+			onExec(query)
+		}
+	}()
+	arg := func(i int) int64 {
+		v, _ := args[i].Value.(int64)
+		return v
+	}
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS"):
+	case strings.Contains(query, `_lock"`):
+		switch {
+		case strings.HasPrefix(query, "INSERT"):
+			if f.lockErr != nil {
+				return f.lockErr
+			}
+			if f.locked {
+				return errors.New("UNIQUE constraint failed: schema_migrations_lock.id")
+			}
+			f.locked, f.owner, f.lockedAt = true, args[0].Value.(string), arg(1)
+		case strings.HasPrefix(query, "UPDATE"):
+			// 刷新锁的时间
+			if !f.locked || f.owner != args[1].Value {
+				return errNoRows
+			}
+			f.lockedAt = arg(0)
+		case strings.Contains(query, "locked_at <"):
+			// 清除过期的锁
+			if !f.locked || f.lockedAt >= arg(0) {
+				return errNoRows
+			}
+			f.locked = false
+		default:
+			// 释放自己持有的锁
+			if !f.locked || f.owner != args[0].Value {
+				return errNoRows
+			}
+			f.locked = false
+		}
+	case strings.HasPrefix(query, `INSERT INTO "schema_migrations"`):
+		f.versions[arg(0)] = arg(2)
+	case strings.HasPrefix(query, `DELETE FROM "schema_migrations"`):
+		delete(f.versions, arg(0))
+	case strings.Contains(query, "FAIL"):
+		return errors.New("syntax error")
+	default:
+		f.execs = append(f.execs, query)
+	}
+	return nil
+}
+
+func (f *fakeDB) Prepare(query string) error {
+	return errors.New("prepare is not supported")
+}
+
+func (f *fakeDB) Exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := f.exec(query, args); err == errNoRows {
+		return fakedb.Result{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return fakedb.Result{Changed: 1}, nil
+}
+
+func (f *fakeDB) Query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := &fakedb.Rows{Cols: []string{"version", "applied_at"}}
+	for v, at := range f.versions {
+		rows.Data = append(rows.Data, []driver.Value{v, at})
+	}
+	return rows, nil
+}
+
+func (f *fakeDB) Begin() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	versions := make(map[int64]int64, len(f.versions))
+	for k, v := range f.versions {
+		versions[k] = v
+	}
+	f.snapshot = &fakeDB{versions: versions, execs: append([]string{}, f.execs...)}
+	f.txs = append(f.txs, "BEGIN")
+	return nil
+}
+
+func (f *fakeDB) Commit() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshot = nil
+	f.txs = append(f.txs, "COMMIT")
+	return nil
+}
+
+func (f *fakeDB) Rollback() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions, f.execs = f.snapshot.versions, f.snapshot.execs
+	f.snapshot = nil
+	f.txs = append(f.txs, "ROLLBACK")
+	return nil
+}
@@ -0,0 +1,492 @@
+// 数据库版本迁移
+// 迁移文件命名为 NNNN_name.up.sql 及 NNNN_name.down.sql，NNNN为版本号
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"go_lib/database"
+	"go_lib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 迁移文件名规则
+var fileReg = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// 需要回滚的迁移没有对应的回滚文件
+var ErrIrreversible = errors.New("migrate: migration has no down file")
+
+// 等待锁超时
+var ErrLockTimeout = errors.New("migrate: timeout waiting for migration lock")
+
+// 单个迁移
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string // 升级SQL
+	Down    string // 回滚SQL，为空表示不可回滚
+}
+
+// 迁移状态
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time // 执行时间，未执行时为零值
+}
+
+// 迁移执行器
+type Migrator struct {
+	db          *database.SqlDB
+	dir         string
+	ctx         context.Context
+	Table       string        // 记录已执行版本的表，默认schema_migrations
+	LockTimeout time.Duration // 等待锁的时间，默认30秒
+	LockExpire  time.Duration // 锁的过期时间，超过该时间的锁视为执行者已退出，默认10分钟
+	owner       string        // 持有锁时的标识
+}
+
+// 新建迁移执行器，dir为迁移文件所在的目录
+func New(db *database.SqlDB, dir string) *Migrator {
+	return &Migrator{
+		db:          db,
+		dir:         dir,
+		ctx:         context.Background(),
+		Table:       "schema_migrations",
+		LockTimeout: 30 * time.Second,
+		LockExpire:  10 * time.Minute,
+	}
+}
+
+// 设置上下文
+func (m *Migrator) WithContext(ctx context.Context) *Migrator {
+	m.ctx = ctx
+	return m
+}
+
+// 读取目录中的迁移文件，按版本号排序
+func (m *Migrator) Load() ([]*Migration, error) {
+	files, _, err := utils.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]*Migration)
+	for _, file := range files {
+		match := fileReg.FindStringSubmatch(filepath.Base(file))
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version in %s", file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := versions[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			versions[version] = mg
+		} else if mg.Name != match[2] {
+			return nil, fmt.Errorf("migrate: duplicate version %d: %s and %s", version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.Up = string(data)
+		} else {
+			mg.Down = string(data)
+		}
+	}
+
+	list := make([]*Migration, 0, len(versions))
+	for _, mg := range versions {
+		if strings.TrimSpace(mg.Up) == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up file", mg.Version)
+		}
+		list = append(list, mg)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// 执行所有未执行的迁移，返回执行的版本
+func (m *Migrator) Up() ([]int64, error) {
+	return m.To(-1)
+}
+
+// 回滚最后一个迁移，返回回滚的版本
+func (m *Migrator) Down() ([]int64, error) {
+	var done []int64
+	err := m.locked(func(migrations []*Migration, applied map[int64]time.Time) error {
+		list := appliedList(migrations, applied)
+		if len(list) == 0 {
+			return nil
+		}
+		last := list[len(list)-1]
+		if err := m.down(last); err != nil {
+			return err
+		}
+		done = append(done, last.Version)
+		return nil
+	})
+	return done, err
+}
+
+// 迁移到指定版本，大于当前版本时升级，小于时回滚，version为-1时升级到最新，为0时全部回滚
+func (m *Migrator) To(version int64) ([]int64, error) {
+	var done []int64
+	err := m.locked(func(migrations []*Migration, applied map[int64]time.Time) error {
+		// 回滚大于目标版本的迁移
+		if version >= 0 {
+			list := appliedList(migrations, applied)
+			for i := len(list) - 1; i >= 0 && list[i].Version > version; i-- {
+				if err := m.down(list[i]); err != nil {
+					return err
+				}
+				done = append(done, list[i].Version)
+			}
+		}
+		for _, mg := range migrations {
+			if version >= 0 && mg.Version > version {
+				break
+			}
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.up(mg); err != nil {
+				return err
+			}
+			done = append(done, mg.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// 获取所有迁移的执行状态
+func (m *Migrator) Status() ([]*Status, error) {
+	migrations, err := m.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.createTables(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Status, 0, len(migrations))
+	for _, mg := range migrations {
+		at, ok := applied[mg.Version]
+		list = append(list, &Status{Version: mg.Version, Name: mg.Name, Applied: ok, AppliedAt: at})
+	}
+	return list, nil
+}
+
+// 加锁后执行fn
+func (m *Migrator) locked(fn func(migrations []*Migration, applied map[int64]time.Time) error) error {
+	migrations, err := m.Load()
+	if err != nil {
+		return err
+	}
+	if err = m.createTables(); err != nil {
+		return err
+	}
+	if err = m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+	defer m.keepLock()()
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	return fn(migrations, applied)
+}
+
+// 已执行且存在文件的迁移，按版本号排序
+func appliedList(migrations []*Migration, applied map[int64]time.Time) []*Migration {
+	var list []*Migration
+	for _, mg := range migrations {
+		if _, ok := applied[mg.Version]; ok {
+			list = append(list, mg)
+		}
+	}
+	return list
+}
+
+// 是否为违反唯一键的错误，支持MySQL、PostgreSQL及SQLite的驱动
+func isDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*mysql.MySQLError); ok {
+		return e.Number == 1062
+	}
+	// PostgreSQL的错误码为23505
+	if e, ok := err.(interface{ SQLState() string }); ok {
+		return e.SQLState() == "23505"
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "duplicate key value") || strings.Contains(msg, "UNIQUE constraint failed")
+}
+
+// 锁表名
+func (m *Migrator) lockTable() string {
+	return m.Table + "_lock"
+}
+
+// 创建版本记录表及锁表
+func (m *Migrator) createTables() error {
+	_, err := m.db.ExecContext(m.ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)", m.db.FormatColumn(m.Table)))
+	if err != nil {
+		return err
+	}
+	_, err = m.db.ExecContext(m.ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INT NOT NULL PRIMARY KEY, owner VARCHAR(255) NOT NULL, locked_at BIGINT NOT NULL)", m.db.FormatColumn(m.lockTable())))
+	return err
+}
+
+// 获取已执行的版本及执行时间
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	list, err := m.db.QueryContext(m.ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.db.FormatColumn(m.Table)))
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]time.Time, len(list))
+	for _, row := range list {
+		version, err := toInt64(row["version"])
+		if err != nil {
+			return nil, err
+		}
+		at, _ := toInt64(row["applied_at"])
+		applied[version] = time.Unix(at, 0)
+	}
+	return applied, nil
+}
+
+// 获取锁，锁表中只能有一条id为1的记录，插入成功即获得锁
+func (m *Migrator) lock() error {
+	host, _ := os.Hostname()
+	// 同一进程中可能有多个执行器，加上时间区分
+	m.owner = fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano())
+	table := m.db.FormatColumn(m.lockTable())
+	deadline := time.Now().Add(m.LockTimeout)
+	for {
+		_, err := m.db.ExecContext(m.ctx, fmt.Sprintf("INSERT INTO %s (id, owner, locked_at) VALUES (1, ?, ?)", table), m.owner, time.Now().Unix())
+		if err == nil {
+			return nil
+		}
+		if ctxErr := m.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// 只有锁已存在时重试，其他错误直接返回
+		if !isDuplicateKey(err) {
+			return err
+		}
+		// 清除过期的锁，清除后立即重试
+		res, err := m.db.ExecContext(m.ctx, fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND locked_at < ?", table), time.Now().Add(-m.LockExpire).Unix())
+		if err == nil {
+			if n, _ := res.RowsAffected(); n > 0 {
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// 执行期间定期刷新锁的时间，避免执行时间超过LockExpire时锁被其他执行者清除，返回停止刷新的函数
+func (m *Migrator) keepLock() func() {
+	interval := m.LockExpire / 3
+	if interval <= 0 {
+		return func() {}
+	}
+	sqlStr := fmt.Sprintf("UPDATE %s SET locked_at = ? WHERE id = 1 AND owner = ?", m.db.FormatColumn(m.lockTable()))
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, _ = m.db.ExecContext(m.ctx, sqlStr, time.Now().Unix(), m.owner)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// 释放锁，只删除自己持有的锁
+// 上下文可能已取消，使用新的上下文避免锁残留到过期
+func (m *Migrator) unlock() {
+	_, _ = m.db.ExecContext(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND owner = ?", m.db.FormatColumn(m.lockTable())), m.owner)
+}
+
+// 执行升级
+func (m *Migrator) up(mg *Migration) error {
+	return m.run(mg, mg.Up, func(db *database.SqlDB) error {
+		_, err := db.ExecContext(m.ctx, fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", db.FormatColumn(m.Table)), mg.Version, mg.Name, time.Now().Unix())
+		return err
+	})
+}
+
+// 执行回滚
+func (m *Migrator) down(mg *Migration) error {
+	if strings.TrimSpace(mg.Down) == "" {
+		return ErrIrreversible
+	}
+	return m.run(mg, mg.Down, func(db *database.SqlDB) error {
+		_, err := db.ExecContext(m.ctx, fmt.Sprintf("DELETE FROM %s WHERE version = ?", db.FormatColumn(m.Table)), mg.Version)
+		return err
+	})
+}
+
+// 执行迁移SQL并更新版本记录，DDL支持事务的数据库在同一个事务中执行
+func (m *Migrator) run(mg *Migration, sqlStr string, record func(db *database.SqlDB) error) error {
+	exec := func(db *database.SqlDB) error {
+		for _, stmt := range SplitStatements(sqlStr) {
+			if _, err := db.ExecContext(m.ctx, stmt); err != nil {
+				return fmt.Errorf("migrate: %d_%s: %v", mg.Version, mg.Name, err)
+			}
+		}
+		return record(db)
+	}
+	if !m.db.Dialect().TransactionalDDL() {
+		return exec(m.db)
+	}
+	return m.db.TransactionContext(m.ctx, func(tx *database.Tx) error {
+		return exec(tx.SqlDB)
+	})
+}
+
+// 将SQL拆分为多条语句，忽略字符串、引号、PostgreSQL的$$及注释中的分号
+// 字符串中的反斜杠按MySQL的规则转义下一个字符
+func SplitStatements(sqlStr string) []string {
+	var (
+		list  []string
+		buf   strings.Builder
+		quote byte
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(buf.String()); stmt != "" {
+			list = append(list, stmt)
+		}
+		buf.Reset()
+	}
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(sqlStr) {
+				buf.WriteByte(c)
+				i++
+				c = sqlStr[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '$' && (i == 0 || !isIdentChar(sqlStr[i-1])):
+			// 函数体等使用$$或$tag$包围，原样保留到结束标记
+			tag := dollarTag(sqlStr[i:])
+			if tag == "" {
+				break
+			}
+			end := strings.Index(sqlStr[i+len(tag):], tag)
+			if end == -1 {
+				end = len(sqlStr)
+			} else {
+				end += i + 2*len(tag)
+			}
+			buf.WriteString(sqlStr[i:end])
+			i = end - 1
+			continue
+		case c == '-' && i+1 < len(sqlStr) && sqlStr[i+1] == '-':
+			// 单行注释，保留行尾的换行
+			end := strings.IndexByte(sqlStr[i:], '\n')
+			if end == -1 {
+				i = len(sqlStr)
+			} else {
+				i += end - 1
+			}
+			continue
+		case c == '/' && i+1 < len(sqlStr) && sqlStr[i+1] == '*':
+			// 多行注释，替换为空格避免前后的内容相连
+			end := strings.Index(sqlStr[i+2:], "*/")
+			if end == -1 {
+				i = len(sqlStr)
+			} else {
+				i += end + 3
+			}
+			buf.WriteByte(' ')
+			continue
+		case c == ';':
+			flush()
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	flush()
+	return list
+}
+
+// 获取s开头的美元符号引用标记，如$$、$body$，$1等参数占位符不是标记
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c >= '0' && c <= '9' && i == 1:
+			return ""
+		case !isIdentChar(c):
+			return ""
+		}
+	}
+	return ""
+}
+
+// 是否为标识符中的字符
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// 转换为int64
+func toInt64(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int64:
+		return val, nil
+	case int:
+		return int64(val), nil
+	case int32:
+		return int64(val), nil
+	case float64:
+		return int64(val), nil
+	case []byte:
+		return strconv.ParseInt(string(val), 10, 64)
+	case string:
+		return strconv.ParseInt(val, 10, 64)
+	}
+	return 0, fmt.Errorf("migrate: invalid version %v", v)
+}
@@ -0,0 +1,342 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 在临时目录中写入迁移文件
+func writeMigrations(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// 测试读取迁移文件
+func TestMigrator_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"0002_add_age.up.sql":       "ALTER TABLE t_user ADD age INT;",
+		"0001_create_user.up.sql":   "CREATE TABLE t_user (id INT);",
+		"0001_create_user.down.sql": "DROP TABLE t_user;",
+		"README.md":                 "ignored",
+		"0003_empty_down.down.sql":  "",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := New(nil, dir)
+	if _, err = m.Load(); err == nil {
+		t.Error("migration without up file should fail")
+	}
+	_ = os.Remove(filepath.Join(dir, "0003_empty_down.down.sql"))
+	list, err := m.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[0].Name != "create_user" || list[0].Down != "DROP TABLE t_user;" || list[1].Down != "" {
+		t.Errorf("unexpected migrations: %+v %+v", list[0], list[1])
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "0002_add_name.up.sql"), []byte("SELECT 1"), 0644)
+	if _, err = m.Load(); err == nil {
+		t.Error("duplicate version should fail")
+	}
+}
+
+// 测试拆分SQL语句
+func TestSplitStatements(t *testing.T) {
+	sqlStr := `-- 创建表; 注释
+CREATE TABLE t_user (id INT, name VARCHAR(20) DEFAULT 'a;b');
+/* 多行;
+注释 */
+INSERT INTO t_user VALUES (1, "x;y");
+SELECT a--x
+FROM t/*y*/WHERE b = 1;
+`
+	expect := []string{
+		"CREATE TABLE t_user (id INT, name VARCHAR(20) DEFAULT 'a;b')",
+		`INSERT INTO t_user VALUES (1, "x;y")`,
+		"SELECT a\nFROM t WHERE b = 1",
+	}
+	if list := SplitStatements(sqlStr); !reflect.DeepEqual(list, expect) {
+		t.Errorf("unexpected statements: %q", list)
+	}
+
+	// PostgreSQL的$$引用及MySQL的反斜杠转义
+	sqlStr = `CREATE FUNCTION f() RETURNS trigger AS $$ BEGIN NEW.a := 1; RETURN NEW; END; $$ LANGUAGE plpgsql;
+CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $fn$ $body$ LANGUAGE sql;
+UPDATE t SET a = $1, b$c = 2;
+INSERT INTO t VALUES ('it\'s;', "a\";b");
+SELECT '\\';`
+	expect = []string{
+		"CREATE FUNCTION f() RETURNS trigger AS $$ BEGIN NEW.a := 1; RETURN NEW; END; $$ LANGUAGE plpgsql",
+		"CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $fn$ $body$ LANGUAGE sql",
+		"UPDATE t SET a = $1, b$c = 2",
+		`INSERT INTO t VALUES ('it\'s;', "a\";b")`,
+		`SELECT '\\'`,
+	}
+	if list := SplitStatements(sqlStr); !reflect.DeepEqual(list, expect) {
+		t.Errorf("unexpected statements: %q", list)
+	}
+}
+
+// 测试升级、回滚及迁移到指定版本
+func TestMigrator_UpDown(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql":   "CREATE TABLE t_user (id INT);",
+		"0001_create_user.down.sql": "DROP TABLE t_user;",
+		"0002_add_age.up.sql":       "ALTER TABLE t_user ADD age INT;",
+		"0002_add_age.down.sql":     "ALTER TABLE t_user DROP age;",
+		"0003_add_name.up.sql":      "ALTER TABLE t_user ADD name VARCHAR(20);\nCREATE INDEX idx_name ON t_user (name);",
+		"0003_add_name.down.sql":    "ALTER TABLE t_user DROP name;",
+	})
+	defer os.RemoveAll(dir)
+	db, fake := newFakeDB("migrate_up_down", false)
+	m := New(db, dir)
+
+	steps := []struct {
+		run    func() ([]int64, error)
+		done   []int64
+		latest int64
+	}{
+		{m.Up, []int64{1, 2, 3}, 3},
+		{m.Up, nil, 3},
+		{m.Down, []int64{3}, 2},
+		{func() ([]int64, error) { return m.To(1) }, []int64{2}, 1},
+		{func() ([]int64, error) { return m.To(3) }, []int64{2, 3}, 3},
+		{func() ([]int64, error) { return m.To(0) }, []int64{3, 2, 1}, 0},
+	}
+	for i, step := range steps {
+		done, err := step.run()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(done, step.done) {
+			t.Errorf("step %d: unexpected versions %v", i, done)
+		}
+		applied := fake.applied()
+		for v := int64(1); v <= 3; v++ {
+			if applied[v] != (v <= step.latest) {
+				t.Errorf("step %d: unexpected applied versions %v", i, applied)
+				break
+			}
+		}
+		if fake.isLocked() {
+			t.Errorf("step %d: lock is not released", i)
+		}
+	}
+	if log := fake.log(); len(log) != 12 || log[3] != "CREATE INDEX idx_name ON t_user (name)" || log[4] != "ALTER TABLE t_user DROP name" {
+		t.Errorf("unexpected statements: %q", log)
+	}
+
+	_, _ = m.To(2)
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || !status[1].Applied || status[1].AppliedAt.IsZero() || status[2].Applied || !status[2].AppliedAt.IsZero() {
+		t.Errorf("unexpected status: %+v %+v", status[1], status[2])
+	}
+}
+
+// 测试没有回滚文件的迁移
+func TestMigrator_Irreversible(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql": "CREATE TABLE t_user (id INT);",
+	})
+	defer os.RemoveAll(dir)
+	db, fake := newFakeDB("migrate_irreversible", false)
+	m := New(db, dir)
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(); err != ErrIrreversible {
+		t.Errorf("expect irreversible, got %v", err)
+	}
+	if !fake.applied()[1] || fake.isLocked() {
+		t.Error("version should stay applied and lock released")
+	}
+}
+
+// 测试迁移锁的等待、过期及错误
+func TestMigrator_Lock(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql": "CREATE TABLE t_user (id INT);",
+	})
+	defer os.RemoveAll(dir)
+	db, fake := newFakeDB("migrate_lock", false)
+	m := New(db, dir)
+	m.LockTimeout = 0
+
+	// 其他执行者持有锁
+	fake.locked, fake.lockedAt = true, time.Now().Unix()
+	if _, err := m.Up(); err != ErrLockTimeout {
+		t.Errorf("expect lock timeout, got %v", err)
+	}
+	if len(fake.applied()) != 0 || !fake.isLocked() {
+		t.Error("migration should not run without the lock")
+	}
+
+	// 过期的锁被清除
+	fake.lockedAt = time.Now().Add(-m.LockExpire - time.Minute).Unix()
+	if done, err := m.Up(); err != nil || len(done) != 1 {
+		t.Errorf("expired lock should be taken over: %v %v", done, err)
+	}
+
+	// 非唯一键冲突的错误不重试
+	m.LockTimeout = time.Minute
+	lockErr := errors.New("permission denied")
+	fake.lockErr = lockErr
+	start := time.Now()
+	if _, err := m.Down(); err != lockErr || time.Since(start) > time.Second {
+		t.Errorf("expect lock error without retry, got %v", err)
+	}
+}
+
+// 测试上下文取消后释放锁
+func TestMigrator_UnlockCanceled(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql": "CREATE TABLE t_user (id INT);",
+	})
+	defer os.RemoveAll(dir)
+	db, fake := newFakeDB("migrate_unlock_canceled", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.onExec = func(query string) {
+		if query == "CREATE TABLE t_user (id INT)" {
+			cancel()
+		}
+	}
+	if _, err := New(db, dir).WithContext(ctx).Up(); err == nil {
+		t.Error("expect canceled error")
+	}
+	if fake.isLocked() {
+		t.Error("lock should be released after the context is canceled")
+	}
+}
+
+// 测试执行期间刷新锁的时间，结束时只释放自己持有的锁
+func TestMigrator_KeepLock(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql": "CREATE TABLE t_user (id INT);",
+		"0002_add_age.up.sql":     "ALTER TABLE t_user ADD age INT;",
+	})
+	defer os.RemoveAll(dir)
+	db, fake := newFakeDB("migrate_keep_lock", false)
+	m := New(db, dir)
+	m.LockExpire = 30 * time.Millisecond
+
+	fake.onExec = func(query string) {
+		switch query {
+		case "CREATE TABLE t_user (id INT)":
+			// 执行时间超过锁的过期时间
+			fake.mu.Lock()
+			fake.lockedAt = 0
+			fake.mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+		case "ALTER TABLE t_user ADD age INT":
+			// 锁被其他执行者接管
+			fake.mu.Lock()
+			fake.owner = "other"
+			fake.mu.Unlock()
+		}
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.lockedAt == 0 {
+		t.Error("lock should be refreshed during migration")
+	}
+	if !fake.locked {
+		t.Error("lock held by other migrator should not be released")
+	}
+}
+
+// 测试DDL支持事务时迁移失败回滚
+func TestMigrator_TransactionalDDL(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_create_user.up.sql":   "CREATE TABLE t_user (id INT);",
+		"0001_create_user.down.sql": "DROP TABLE t_user;",
+		"0002_broken.up.sql":        "CREATE TABLE t_order (id INT);\nFAIL;",
+	})
+	defer os.RemoveAll(dir)
+
+	for _, ddl := range []bool{true, false} {
+		db, fake := newFakeDB("migrate_ddl_"+strconv.FormatBool(ddl), ddl)
+		done, err := New(db, dir).Up()
+		if err == nil || !reflect.DeepEqual(done, []int64{1}) {
+			t.Errorf("ddl %v: unexpected result %v %v", ddl, done, err)
+		}
+		if applied := fake.applied(); !applied[1] || applied[2] {
+			t.Errorf("ddl %v: unexpected applied versions %v", ddl, applied)
+		}
+		expectLog := []string{"CREATE TABLE t_user (id INT)"}
+		var expectTxs []string
+		if ddl {
+			// 失败的迁移整体回滚
+			expectTxs = []string{"BEGIN", "COMMIT", "BEGIN", "ROLLBACK"}
+		} else {
+			// 不支持事务时已执行的语句保留
+			expectLog = append(expectLog, "CREATE TABLE t_order (id INT)")
+		}
+		if log := fake.log(); !reflect.DeepEqual(log, expectLog) {
+			t.Errorf("ddl %v: unexpected statements %q", ddl, log)
+		}
+		if !reflect.DeepEqual(fake.txs, expectTxs) {
+			t.Errorf("ddl %v: unexpected transactions %v", ddl, fake.txs)
+		}
+	}
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "pq: error"
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+// 测试判断唯一键冲突的错误
+func TestIsDuplicateKey(t *testing.T) {
+	tests := map[error]bool{
+		nil:                             false,
+		&mysql.MySQLError{Number: 1062}: true,
+		&mysql.MySQLError{Number: 1064}: false,
+		sqlStateError("23505"):          true,
+		sqlStateError("42601"):          false,
+		errors.New("UNIQUE constraint failed: t.id"): true,
+		errors.New("syntax error"):                   false,
+	}
+	for err, expect := range tests {
+		if isDuplicateKey(err) != expect {
+			t.Errorf("%v: expect %v", err, expect)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// 批量新增默认的最大语句长度，与MySQL 5.7的max_allowed_packet默认值一致
+const defaultMaxPacketSize = 4 << 20
+
+// 批量新增选项
+type InsertManyOptions struct {
+	ChunkSize     int  // 每批最大行数，0表示按占位符上限自动计算
+	MaxPacketSize int  // 每批数据的最大字节数（估算），0表示4MB
+	Transaction   bool // 是否在事务中执行，任意一批失败则全部回滚
+}
+
+// 批量新增结果
+type InsertManyResult struct {
+	Ids    []int   // 新增记录的ID，按数据顺序排列，失败的批次为0
+	Rows   int     // 成功新增的行数
+	Errors []error // 每批的执行错误，成功为nil
+}
+
+// 批量新增
+// rows为结构体、结构体指针、map或utils.M的切片，所有行的字段必须一致
+func (m *SqlDB) InsertMany(table string, rows interface{}, opts ...*InsertManyOptions) (*InsertManyResult, error) {
+	return m.InsertManyContext(context.Background(), table, rows, opts...)
+}
+
+// 批量新增，支持上下文
+// 结构体实现了BeforeInsertHook、AfterInsertHook时，新增前对每行调用，全部成功后对每行调用
+func (m *SqlDB) InsertManyContext(ctx context.Context, table string, rows interface{}, opts ...*InsertManyOptions) (*InsertManyResult, error) {
+	if err := m.beforeInsert(ctx, rows); err != nil {
+		m.setLastError(err)
+		return nil, err
+	}
+	result, err := m.insertMany(ctx, table, rows, m.primaryKey(table), opts...)
+	if err == nil {
+		if err = m.afterInsert(ctx, rows); err != nil {
+			m.setLastError(err)
+		}
+	}
+	return result, err
+}
+
+// 批量新增，pk为需要返回的自增主键
+func (m *SqlDB) insertMany(ctx context.Context, table string, rows interface{}, pk string, opts ...*InsertManyOptions) (*InsertManyResult, error) {
+	opt := &InsertManyOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		opt = opts[0]
+	}
+	columns, values, err := m.batchValues(table, rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return &InsertManyResult{}, nil
+	}
+
+	// 需要事务且当前不在事务中
+	if opt.Transaction && m.tx == nil {
+		var result *InsertManyResult
+		err = m.TransactionContext(ctx, func(tx *Tx) error {
+			var err error
+			result, err = tx.insertChunks(ctx, table, pk, columns, values, opt, true)
+			return err
+		})
+		// 回滚后之前成功的批次也未写入
+		if err != nil && result != nil {
+			result.Rows = 0
+			result.Ids = make([]int, len(values))
+		}
+		return result, err
+	}
+	return m.insertChunks(ctx, table, pk, columns, values, opt, opt.Transaction)
+}
+
+// 将数据转换为字段列表及每行的值，并填充表的时间字段
+func (m *SqlDB) batchValues(table string, rows interface{}) ([]string, [][]interface{}, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, nil, errors.New("database: InsertMany requires a slice")
+	}
+
+	var columns []string
+	values := make([][]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		data, err := m.insertTimestamps(table, v.Index(i).Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+		// 以第一行的字段为准
+		if columns == nil {
+			if columns = sortedKeys(data); len(columns) == 0 {
+				return nil, nil, errors.New("database: InsertMany requires rows with columns")
+			}
+		}
+		if len(data) != len(columns) {
+			return nil, nil, fmt.Errorf("database: row %d has %d columns, expect %d", i, len(data), len(columns))
+		}
+		row := make([]interface{}, 0, len(columns))
+		for _, c := range columns {
+			val, ok := data[c]
+			if !ok {
+				return nil, nil, fmt.Errorf("database: row %d missing column %s", i, c)
+			}
+			row = append(row, val)
+		}
+		values = append(values, row)
+	}
+	return columns, values, nil
+}
+
+// 分批执行新增，stopOnError为true时遇到错误立即返回
+func (m *SqlDB) insertChunks(ctx context.Context, table string, pk string, columns []string, values [][]interface{}, opt *InsertManyOptions, stopOnError bool) (*InsertManyResult, error) {
+	result := &InsertManyResult{Ids: make([]int, len(values))}
+	var failed int
+	for _, chunk := range m.splitChunks(columns, values, opt) {
+		ids, err := m.insertChunk(ctx, table, pk, columns, values[chunk[0]:chunk[1]])
+		result.Errors = append(result.Errors, err)
+		if err != nil {
+			failed++
+			if stopOnError {
+				return result, err
+			}
+			continue
+		}
+		copy(result.Ids[chunk[0]:chunk[1]], ids)
+		result.Rows += chunk[1] - chunk[0]
+	}
+	if failed > 0 {
+		return result, fmt.Errorf("database: %d of %d insert chunks failed", failed, len(result.Errors))
+	}
+	return result, nil
+}
+
+// 按行数、占位符数量及数据大小拆分批次，返回每批的起止下标
+func (m *SqlDB) splitChunks(columns []string, values [][]interface{}, opt *InsertManyOptions) [][2]int {
+	maxRows := m.Dialect().MaxPlaceholders() / len(columns)
+	if opt.ChunkSize > 0 && opt.ChunkSize < maxRows {
+		maxRows = opt.ChunkSize
+	}
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	maxSize := opt.MaxPacketSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxPacketSize
+	}
+
+	var chunks [][2]int
+	start, size := 0, 0
+	for i, row := range values {
+		rowSize := estimateSize(row)
+		if i > start && (i-start >= maxRows || size+rowSize > maxSize) {
+			chunks = append(chunks, [2]int{start, i})
+			start, size = i, 0
+		}
+		size += rowSize
+	}
+	return append(chunks, [2]int{start, len(values)})
+}
+
+// 估算一行数据的字节数
+func estimateSize(row []interface{}) int {
+	size := 0
+	for _, v := range row {
+		switch val := v.(type) {
+		case string:
+			size += len(val) + 3
+		case []byte:
+			size += len(val)*2 + 3
+		default:
+			size += 24
+		}
+	}
+	return size
+}
+
+// 执行一批新增，返回每行的ID
+func (m *SqlDB) insertChunk(ctx context.Context, table string, pk string, columns []string, rows [][]interface{}) ([]int, error) {
+	valMask := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for _, row := range rows {
+		mask := make([]string, 0, len(row))
+		for _, v := range row {
+			str, val, err := m.valueSql(v)
+			if err != nil {
+				return nil, err
+			}
+			mask = append(mask, str)
+			args = append(args, val...)
+		}
+		valMask = append(valMask, "("+strings.Join(mask, ",")+")")
+	}
+	sqlStr := fmt.Sprintf("INSERT INTO %s(%s) VALUES%s", m.FormatColumn(table), strings.Join(quoteColumns(m.Dialect(), columns), ","), strings.Join(valMask, ","))
+
+	ids := make([]int, 0, len(rows))
+	// 通过RETURNING获取所有ID
+	if returning := m.Dialect().Returning(pk); returning != "" {
+		list, err := m.QueryContext(ctx, sqlStr+" "+returning, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range list {
+			id, _ := toInt64(row[pk])
+			ids = append(ids, int(id))
+		}
+		return ids, nil
+	}
+
+	res, err := m.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	lastId, err := res.LastInsertId()
+	if err != nil || lastId == 0 {
+		return make([]int, len(rows)), nil
+	}
+	first := m.Dialect().FirstInsertId(lastId, len(rows))
+	for i := range rows {
+		ids = append(ids, int(first)+i)
+	}
+	return ids, nil
+}
+
+// 转换为int64
+func toInt64(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	case float64:
+		return int64(val), true
+	}
+	return 0, false
+}
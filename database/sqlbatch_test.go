@@ -0,0 +1,74 @@
+package database
+
+import (
+	"go_lib/utils"
+	"testing"
+)
+
+// 测试批量新增分批
+func TestSqlDB_InsertMany(t *testing.T) {
+	db, fake := newFakeSqlDB("insert_many", MysqlDialect{})
+
+	users := []User{
+		{Name: "张三", Age: 18},
+		{Name: "李四", Age: 19},
+		{Name: "王五", Age: 20},
+		{Name: "赵六", Age: 21},
+		{Name: "孙七", Age: 22},
+	}
+	res, err := db.Table("t_user").InsertMany(users, &InsertManyOptions{ChunkSize: 2, Transaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 5 || len(res.Errors) != 3 || len(res.Ids) != 5 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	log := fake.log()
+	if len(log) != 5 || log[0] != "BEGIN" || log[4] != "COMMIT" {
+		t.Fatalf("unexpected sql: %q", log)
+	}
+	if log[1] != "INSERT INTO `t_user`(`age`,`name`,`phone`,`sex`) VALUES(?,?,?,?),(?,?,?,?)" {
+		t.Errorf("unexpected sql: %s", log[1])
+	}
+
+	// 字段不一致
+	_, err = db.InsertMany("t_user", []utils.M{{"name": "a"}, {"age": 1}})
+	if err == nil {
+		t.Error("inconsistent columns should return error")
+	}
+	// 没有字段
+	_, err = db.InsertMany("t_user", []utils.M{{}, {}})
+	if err == nil {
+		t.Error("rows without columns should return error")
+	}
+}
+
+// 测试事务中的批次失败时回滚，结果中不包含回滚的行
+func TestSqlDB_InsertManyRollback(t *testing.T) {
+	db, fake := newFakeSqlDB("insert_many_rollback", MysqlDialect{})
+
+	rows := []utils.M{{"name": "a"}, {"name": "b"}, {"name": "FAIL"}}
+	res, err := db.InsertMany("t_user", rows, &InsertManyOptions{ChunkSize: 2, Transaction: true})
+	if err == nil {
+		t.Fatal("expect insert error")
+	}
+	if res.Rows != 0 || res.Ids[0] != 0 || res.Ids[1] != 0 || len(res.Errors) != 2 || res.Errors[1] == nil {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if log := fake.log(); log[len(log)-1] != "ROLLBACK" {
+		t.Errorf("expect rollback, got %q", log)
+	}
+}
+
+// 测试按占位符上限拆分
+func TestSqlDB_SplitChunks(t *testing.T) {
+	db := &SqlDB{dialect: SqliteDialect{}}
+	values := make([][]interface{}, 1000)
+	for i := range values {
+		values[i] = []interface{}{1, "a", "b"}
+	}
+	chunks := db.splitChunks([]string{"a", "b", "c"}, values, &InsertManyOptions{})
+	if len(chunks) != 4 || chunks[0] != [2]int{0, 333} || chunks[3] != [2]int{999, 1000} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+}
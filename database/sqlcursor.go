@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go_lib/utils"
+	"reflect"
+)
+
+// 逐行读取查询结果的游标，不会一次性加载所有记录，使用后需要调用Close
+//
+//	cur, err := db.Table("t_user").Cursor()
+//	defer cur.Close()
+//	for cur.Next() {
+//		var u User
+//		err = cur.Scan(&u)
+//	}
+//	err = cur.Err()
+type Cursor struct {
+	db     *SqlDB
+	rows   *sql.Rows
+	ctx    context.Context
+	event  *QueryEvent
+	reader *rowReader
+	count  int64
+	err    error
+	closed bool
+}
+
+// 执行查询并返回游标
+func (m *SqlDB) Cursor(sqlStr string, args ...interface{}) (*Cursor, error) {
+	return m.CursorContext(context.Background(), sqlStr, args...)
+}
+
+// 执行查询并返回游标，支持上下文
+// 查询钩子的AfterQuery在Close时调用，耗时包含读取记录的时间
+func (m *SqlDB) CursorContext(ctx context.Context, sqlStr string, args ...interface{}) (*Cursor, error) {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	rows, err := m.doQuery(ctx, sqlStr, args...)
+	if err != nil {
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
+		return nil, err
+	}
+	return &Cursor{db: m, rows: rows, ctx: ctx, event: event}, nil
+}
+
+// 移动到下一条记录，没有记录或出错时返回false并自动关闭游标
+func (c *Cursor) Next() bool {
+	if c.closed {
+		return false
+	}
+	if !c.rows.Next() {
+		c.err = c.rows.Err()
+		_ = c.Close()
+		return false
+	}
+	c.count++
+	return true
+}
+
+// 获取当前记录的字段
+func (c *Cursor) Columns() ([]string, error) {
+	if c.closed {
+		return nil, errors.New("database: cursor is closed")
+	}
+	return c.rows.Columns()
+}
+
+// 将当前记录扫描到dest中，dest可以是结构体指针或*utils.M
+func (c *Cursor) Scan(dest interface{}) error {
+	if c.closed {
+		return errors.New("database: cursor is closed")
+	}
+	var err error
+	switch d := dest.(type) {
+	case *utils.M:
+		*d, err = c.row()
+	case *map[string]interface{}:
+		var row utils.M
+		row, err = c.row()
+		*d = row
+	default:
+		v := reflect.ValueOf(dest)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("database: unsupported scan destination %T", dest)
+		}
+		var columns []string
+		if columns, err = c.rows.Columns(); err == nil {
+			err = c.db.scanStruct(c.rows, columns, v.Elem())
+		}
+		if err == nil {
+			err = c.db.afterFind(c.ctx, dest)
+		}
+	}
+	if err != nil {
+		c.err = err
+	}
+	return err
+}
+
+// 读取当前记录
+func (c *Cursor) row() (utils.M, error) {
+	if c.reader == nil {
+		reader, err := c.db.newRowReader(c.rows)
+		if err != nil {
+			return nil, err
+		}
+		c.reader = reader
+	}
+	return c.reader.read(c.rows)
+}
+
+// 获取遍历及扫描时的错误
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// 关闭游标，可以多次调用
+func (c *Cursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.rows.Close()
+	if c.err == nil {
+		c.err = err
+	}
+	if c.err != nil {
+		c.err = c.db.handleError(c.ctx, c.err)
+	}
+	c.db.afterQuery(c.ctx, c.event, c.count, c.err)
+	return err
+}
+
+// 按当前条件查询并返回游标，查询后清除查询条件
+// 分表时查询条件需要定位到单个分片
+func (t *DBTable) Cursor() (*Cursor, error) {
+	defer t.Clear()
+	if err := t.checkError(); err != nil {
+		return nil, err
+	}
+	if t.sqlStr == "" {
+		t.Query()
+	}
+	shards, err := t.shards()
+	if err != nil {
+		t.db.setLastError(err)
+		return nil, err
+	}
+	switch len(shards) {
+	case 0:
+		return t.db.CursorContext(t.context(), t.sqlStr, t.queryArgs()...)
+	case 1:
+		from := t.db.FormatColumn(shards[0].Table) + " " + t.db.FormatColumn(t.table)
+		return t.shardDB(shards[0]).CursorContext(t.context(), t.selectSql(from, t.limitStr), t.queryArgs()...)
+	}
+	err = fmt.Errorf("database: cursor on %s needs the shard key to locate a single shard", t.table)
+	t.db.setLastError(err)
+	return nil, err
+}
+
+// 按当前条件逐条遍历记录，fn返回错误时停止遍历并返回该错误，查询后清除查询条件
+func (t *DBTable) Each(fn func(row utils.M) error) error {
+	cur, err := t.Cursor()
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for cur.Next() {
+		var row utils.M
+		if err = cur.Scan(&row); err != nil {
+			return err
+		}
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
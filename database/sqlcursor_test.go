@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"go_lib/utils"
+	"testing"
+)
+
+// 测试逐条遍历及提前结束
+func TestDBTable_Each(t *testing.T) {
+	db, fake := newFakeSqlDB("each", MysqlDialect{})
+	fake.setRows([]string{"id", "name"},
+		[]driver.Value{int64(1), []byte("a")},
+		[]driver.Value{int64(2), []byte("b")},
+		[]driver.Value{int64(3), []byte("c")},
+	)
+
+	var ids []interface{}
+	err := db.Table("t_user").Where(utils.M{"status": 1}, "").Each(func(row utils.M) error {
+		ids = append(ids, row["id"])
+		return nil
+	})
+	if err != nil || len(ids) != 3 || ids[2] != int64(3) {
+		t.Errorf("unexpected result: %v %v", ids, err)
+	}
+
+	stop := errors.New("stop")
+	ids = nil
+	err = db.Table("t_user").Each(func(row utils.M) error {
+		ids = append(ids, row["id"])
+		if len(ids) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || len(ids) != 2 {
+		t.Errorf("unexpected result: %v %v", ids, err)
+	}
+	if fake.open != 0 {
+		t.Errorf("rows not closed: %d", fake.open)
+	}
+}
+
+// 测试游标扫描到结构体
+func TestCursor_Scan(t *testing.T) {
+	db, fake := newFakeSqlDB("cursor", MysqlDialect{})
+	fake.setRows([]string{"id", "name"},
+		[]driver.Value{int64(1), []byte("a")},
+		[]driver.Value{int64(2), []byte("b")},
+	)
+	metrics := NewMetrics(nil)
+	db.AddHook(metrics)
+
+	cur, err := db.Table("t_user").Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for cur.Next() {
+		var u struct {
+			Id   int64
+			Name string
+		}
+		if err = cur.Scan(&u); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, u.Name)
+	}
+	if cur.Err() != nil || len(names) != 2 || names[1] != "b" {
+		t.Errorf("unexpected result: %v %v", names, cur.Err())
+	}
+	if err = cur.Scan(&utils.M{}); err == nil {
+		t.Error("expect error after cursor closed")
+	}
+	if fake.open != 0 {
+		t.Errorf("rows not closed: %d", fake.open)
+	}
+	if snap := metrics.Snapshot().Queries; len(snap) != 1 || snap[0].Rows != 2 {
+		t.Errorf("unexpected metrics: %+v", snap)
+	}
+
+	// 提前关闭
+	cur, _ = db.Table("t_user").Cursor()
+	cur.Next()
+	if err = cur.Close(); err != nil || cur.Close() != nil || cur.Next() {
+		t.Error("close failed")
+	}
+	if fake.open != 0 {
+		t.Errorf("rows not closed: %d", fake.open)
+	}
+}
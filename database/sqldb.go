@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,25 +9,51 @@ import (
 	"go_lib/utils"
 	"log"
 	"reflect"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type SqlDB struct {
-	db        *sql.DB
-	table     string
-	debug     bool
-	LastSql   string
-	LastArgs  []interface{}
-	lastError error
-	query     interface{}
-	tx        *sql.Tx
+	db         *sql.DB
+	table      string
+	debug      bool
+	LastSql    string // 最后执行的写入语句，查询不记录
+	LastArgs   []interface{}
+	lastError  error
+	query      interface{}
+	tx         *sql.Tx
+	dialect    Dialect
+	strictScan bool // 扫描结构体时是否严格匹配字段
+	// 查询结果是否保持字符串格式
+	stringResult bool
+	stmts        *stmtCache // 预处理语句缓存
+	hooks        []Hook     // SQL执行钩子
+	replicas     *replicaSet
+	shards       map[string]ShardRule   // 逻辑表的分表规则
+	softDeletes  map[string]string      // 软删除的表及删除时间字段
+	timestamps   map[string]*Timestamps // 自动维护时间字段的表
+	primaryKeys  map[string]string      // 表的自增主键，未设置时为id
 }
 
 var SqlDrivers = make(map[string]*sql.DB)
 
+// SqlDrivers的读写锁，统计接口会在其他协程中读取
+var sqlDriversLock sync.RWMutex
+
+// LastSql、LastArgs及lastError的读写锁，同一个SqlDB可以在多个协程中使用
+var lastLock sync.RWMutex
+
+// SQL执行器，*sql.DB与*sql.Tx均实现了该接口
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // 数据库配置
 type DBConfig struct {
+	DBDriver   string `json:"db_driver" yaml:"db_driver"` // 数据库类型：mysql、postgres、sqlite3，默认mysql
 	DBHost     string `json:"db_host" yaml:"db_host"`
 	DBPort     string `json:"db_port" yaml:"db_port"`
 	DBName     string `json:"db_name" yaml:"db_name"`
@@ -34,7 +61,19 @@ type DBConfig struct {
 	DBPassword string `json:"db_password" yaml:"db_password"`
 	DBOpenSize int    `json:"db_open_size" yaml:"db_open_size"` // 打开连接数
 	DBIdleSize int    `json:"db_idle_size" yaml:"db_idle_size"` // 空闲连接数
-	DBDebug    bool   `json:"db_debug" yaml:"db_debug"`
+	DBDebug    bool   `json:"db_debug" yaml:"db_debug"`         // 调试模式，记录所有SQL并代入参数
+	// 慢查询阈值（毫秒），大于0时记录慢查询及错误
+	DBSlowQuery int `json:"db_slow_query" yaml:"db_slow_query"`
+	// 记录SQL执行统计到DefaultMetrics
+	DBMetrics bool `json:"db_metrics" yaml:"db_metrics"`
+	// 从库列表，只读查询轮询或按权重使用从库，写入及事务使用主库
+	DBReplicas []DBReplica `json:"db_replicas" yaml:"db_replicas"`
+	// 从库选择策略：round_robin、weighted，默认round_robin
+	DBReplicaPolicy string `json:"db_replica_policy" yaml:"db_replica_policy"`
+	// 查询结果中的[]byte全部转换为字符串，兼容旧版本
+	DBStringResult bool `json:"db_string_result" yaml:"db_string_result"`
+	// 预处理语句缓存数量，0表示不缓存
+	DBStmtCacheSize int `json:"db_stmt_cache_size" yaml:"db_stmt_cache_size"`
 }
 
 // 数据库字段
@@ -45,98 +84,195 @@ type DBColumn struct {
 
 type DM map[string]interface{}
 
-// 字段验证规则
-var columnReg = regexp.MustCompile(`(.+?)\[(\+|-|!|>|<|>=|<=|like)]`)
+// 初始化数据库连接，根据DBDriver选择方言
+// 除MySQL外，需要调用方自行引入对应的驱动包
+func InitSqlDb(conf *DBConfig) (*sql.DB, error) {
+	sqlDb, err := openSqlDb(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = sqlDb.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return sqlDb, nil
+}
 
-// 初始化MySQL
-func InitMysqlDb(conf *DBConfig) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
+// 打开数据库连接池，相同的DSN共用一个连接池
+func openSqlDb(conf *DBConfig) (*sql.DB, error) {
+	dialect, err := GetDialect(conf.DBDriver)
+	if err != nil {
+		return nil, err
+	}
+	dsn := dialect.Dsn(conf)
+	key := dialect.DriverName() + ":" + dsn
 
-	if db, ok := SqlDrivers[dsn]; ok {
+	sqlDriversLock.Lock()
+	defer sqlDriversLock.Unlock()
+	if db, ok := SqlDrivers[key]; ok {
 		return db, nil
 	}
-	fmt.Println(dsn)
-	mysqlDb, err := sql.Open("mysql", dsn)
+	sqlDb, err := sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		return nil, err
 	}
 	// 设置最大打开连接数
-	mysqlDb.SetMaxOpenConns(conf.DBOpenSize)
+	sqlDb.SetMaxOpenConns(conf.DBOpenSize)
 	// 设置最大空闲连接数
-	mysqlDb.SetMaxIdleConns(conf.DBIdleSize)
+	sqlDb.SetMaxIdleConns(conf.DBIdleSize)
+	SqlDrivers[key] = sqlDb
+	return sqlDb, nil
+}
+
+// 初始化MySQL
+func InitMysqlDb(conf *DBConfig) (*sql.DB, error) {
+	return InitSqlDb(conf)
+}
 
-	err = mysqlDb.Ping()
+// 实例化数据库
+func NewSqlDB(conf *DBConfig) (*SqlDB, error) {
+	dialect, err := GetDialect(conf.DBDriver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := InitSqlDb(conf)
 	if err != nil {
 		return nil, err
 	}
-	SqlDrivers[dsn] = mysqlDb
-	return mysqlDb, nil
+	sqlDb := &SqlDB{db: db, debug: conf.DBDebug, dialect: dialect, stringResult: conf.DBStringResult}
+	if len(conf.DBReplicas) > 0 {
+		if sqlDb.replicas, err = newReplicaSet(conf); err != nil {
+			return nil, err
+		}
+	}
+	sqlDb.SetStmtCache(conf.DBStmtCacheSize)
+	if conf.DBDebug || conf.DBSlowQuery > 0 {
+		logger := NewQueryLogger()
+		logger.SlowThreshold = time.Duration(conf.DBSlowQuery) * time.Millisecond
+		logger.SlowOnly = !conf.DBDebug
+		logger.Interpolate = conf.DBDebug
+		sqlDb.AddHook(logger)
+	}
+	if conf.DBMetrics {
+		sqlDb.AddHook(DefaultMetrics)
+	}
+	return sqlDb, nil
 }
 
 // 实例化MySQL
 func NewMysqlDB(conf *DBConfig) (*SqlDB, error) {
-	SqlDrivers, err := InitMysqlDb(conf)
-	if err != nil {
-		return nil, err
+	return NewSqlDB(conf)
+}
+
+// 获取当前方言
+func (m *SqlDB) Dialect() Dialect {
+	if m.dialect == nil {
+		return MysqlDialect{}
 	}
-	mysql := &SqlDB{db: SqlDrivers, debug: conf.DBDebug}
-	return mysql, nil
+	return m.dialect
 }
 
 func (m *SqlDB) Table(tableName string) *DBTable {
 	return NewDBTable(m, tableName)
 }
 
-// 开启事务
-func (m *SqlDB) BeginTrans() error {
-	var err error
-	m.tx, err = m.db.Begin()
-	return err
+// 新增
+func (m *SqlDB) Insert(table string, orgData interface{}) (int, bool) {
+	id, err := m.InsertContext(context.Background(), table, orgData)
+	return id, err == nil
 }
 
-// 提交事务
-func (m *SqlDB) Commit() error {
-	err := m.tx.Commit()
-	return err
+// 新增，支持上下文
+func (m *SqlDB) InsertContext(ctx context.Context, table string, orgData interface{}) (int, error) {
+	if err := m.beforeInsert(ctx, orgData); err != nil {
+		m.setLastError(err)
+		return 0, err
+	}
+	data, err := m.insertTimestamps(table, orgData)
+	if err != nil {
+		return 0, err
+	}
+	id, err := m.insert(ctx, table, data, m.primaryKey(table))
+	if err != nil {
+		return 0, err
+	}
+	if err = m.afterInsert(ctx, orgData); err != nil {
+		m.setLastError(err)
+		return id, err
+	}
+	return id, nil
 }
 
-// 回滚事务
-func (m *SqlDB) Rollback() error {
-	err := m.tx.Rollback()
-	return err
+// 设置表的自增主键，用于不支持LastInsertId的数据库获取新增ID，column为空时表示没有自增主键
+func (m *SqlDB) RegisterPrimaryKey(table string, column string) {
+	if m.primaryKeys == nil {
+		m.primaryKeys = make(map[string]string)
+	}
+	m.primaryKeys[table] = column
 }
 
-// 新增
-func (m *SqlDB) Insert(table string, orgData interface{}) (int, bool) {
+// 获取表的自增主键，未设置时为id
+func (m *SqlDB) primaryKey(table string) string {
+	if pk, ok := m.primaryKeys[table]; ok {
+		return pk
+	}
+	return "id"
+}
+
+// 新增，pk为需要返回的自增主键
+func (m *SqlDB) insert(ctx context.Context, table string, orgData interface{}, pk string) (int, error) {
 	var columns []string
 	var values []interface{}
 	var valMask []string
 	data, err := ConvertData(orgData)
 	if err != nil {
-		return 0, false
+		return 0, err
 	}
-	for k, v := range data {
+	for _, k := range sortedKeys(data) {
+		mask, args, err := m.valueSql(data[k])
+		if err != nil {
+			return 0, err
+		}
 		columns = append(columns, m.FormatColumn(k))
-		values = append(values, v)
-		valMask = append(valMask, "?")
+		values = append(values, args...)
+		valMask = append(valMask, mask)
+	}
+	sqlStr := fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s)", m.FormatColumn(table), strings.Join(columns, ","), strings.Join(valMask, ","))
+	// 不支持LastInsertId的数据库通过RETURNING获取自增ID
+	if returning := m.Dialect().Returning(pk); returning != "" {
+		var id int
+		if err = m.scanRow(ctx, sqlStr+" "+returning, values, &id); err != nil {
+			return 0, err
+		}
+		return id, nil
 	}
-	sqlStr := fmt.Sprintf("INSERT INTO %s(%s) VALUE(%s)", table, strings.Join(columns, ","), strings.Join(valMask, ","))
-	res, err := m.Exec(sqlStr)
+	res, err := m.ExecContext(ctx, sqlStr, values...)
 	if err != nil {
-		return 0, false
+		return 0, err
 	}
 	id, _ := res.LastInsertId()
-	return int(id), false
+	return int(id), nil
 }
 
 // 删除
 func (m *SqlDB) Delete(where utils.M, table string) (int, error) {
-	var values []interface{}
-	whereStr, whereVal := m.ProcessWhere(where, "AND", table)
-	values = append(values, whereVal)
-	sqlStr := fmt.Sprintf("DELETE FROM %s WHERE %s", table, whereStr)
+	return m.DeleteContext(context.Background(), where, table)
+}
 
-	res, err := m.Exec(sqlStr, values...)
+// 删除，支持上下文
+func (m *SqlDB) DeleteContext(ctx context.Context, where utils.M, table string) (int, error) {
+	return m.delete(ctx, []utils.M{where}, table)
+}
+
+// 删除，多组条件之间使用AND连接
+func (m *SqlDB) delete(ctx context.Context, wheres []utils.M, table string) (int, error) {
+	whereStr, values, err := m.buildWheres(wheres, table)
+	if err != nil {
+		return 0, err
+	}
+	sqlStr := fmt.Sprintf("DELETE FROM %s WHERE %s", m.FormatColumn(table), whereStr)
+
+	res, err := m.ExecContext(ctx, sqlStr, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -150,252 +286,330 @@ func (m *SqlDB) Delete(where utils.M, table string) (int, error) {
 
 // 更新
 func (m *SqlDB) Update(data utils.M, where utils.M, table string) error {
+	return m.UpdateContext(context.Background(), data, where, table)
+}
+
+// 更新，支持上下文
+func (m *SqlDB) UpdateContext(ctx context.Context, data utils.M, where utils.M, table string) error {
+	var wheres []utils.M
+	if where != nil {
+		wheres = []utils.M{where}
+	}
+	_, err := m.update(ctx, m.updateTimestamps(table, data, nil), wheres, table)
+	return err
+}
+
+// 更新，不处理时间字段，返回受影响的行数，多组条件之间使用AND连接
+func (m *SqlDB) update(ctx context.Context, data utils.M, wheres []utils.M, table string) (int64, error) {
 	var values []interface{}
 	var tmp []string
-	for i, v := range data {
+	for _, i := range sortedKeys(data) {
+		v := data[i]
 		filed := m.explainColumn(i)
+		if !updateOperators[filed.Icon] {
+			return 0, fmt.Errorf("database: unsupported update operator [%s] in %s", filed.Icon, i)
+		}
+		mask, args, err := m.valueSql(v)
+		if err != nil {
+			return 0, err
+		}
 		// 自增、自减
 		if filed.Icon == "+" || filed.Icon == "-" {
 			// name[+] => name = name + ?
-			tmp = append(tmp, fmt.Sprintf("%s = %s %s ?", m.FormatColumn(filed.Field), m.FormatColumn(filed.Field), filed.Icon))
+			tmp = append(tmp, fmt.Sprintf("%s = %s %s %s", m.FormatColumn(filed.Field), m.FormatColumn(filed.Field), filed.Icon, mask))
 		} else {
-			tmp = append(tmp, fmt.Sprintf("%s %s ?", m.FormatColumn(filed.Field), filed.Icon))
+			tmp = append(tmp, fmt.Sprintf("%s %s %s", m.FormatColumn(filed.Field), filed.Icon, mask))
 		}
-		values = append(values, v)
+		values = append(values, args...)
 	}
 	// 组装SQL语句
 	sqlStr := fmt.Sprintf("UPDATE %s SET %s", m.FormatColumn(table), strings.Join(tmp, ","))
 
-	if where != nil {
-		whereStr, whereVal := m.ProcessWhere(where, "AND", table)
-		values = append(values, whereVal)
+	if len(wheres) > 0 {
+		whereStr, whereVal, err := m.buildWheres(wheres, table)
+		if err != nil {
+			return 0, err
+		}
+		values = append(values, whereVal...)
 		sqlStr = fmt.Sprintf("%s WHERE %s", sqlStr, whereStr)
 	}
 
 	// 执行SQL
-	_, err := m.Exec(sqlStr, values...)
-	return err
+	res, err := m.ExecContext(ctx, sqlStr, values...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 // 查询
 func (m *SqlDB) Query(sqlStr string, args ...interface{}) ([]utils.M, error) {
-	rows, err := m.db.Query(sqlStr, args...)
+	return m.QueryContext(context.Background(), sqlStr, args...)
+}
+
+// 查询，支持上下文
+func (m *SqlDB) QueryContext(ctx context.Context, sqlStr string, args ...interface{}) ([]utils.M, error) {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	rows, err := m.doQuery(ctx, sqlStr, args...)
 	if err != nil {
-		if m.debug {
-			m.PrintError(err)
-		}
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
 		return nil, err
 	}
 	defer func() {
 		_ = rows.Close()
 
 	}()
-	return m.FetchAll(rows)
+	results, err := m.FetchAll(rows)
+	if err != nil {
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
+		return nil, err
+	}
+	m.afterQuery(ctx, event, int64(len(results)), nil)
+	return results, nil
 }
 
 func (m *SqlDB) QueryRow(sqlStr string, args ...interface{}) *sql.Row {
-	return m.db.QueryRow(sqlStr, args...)
+	return m.QueryRowContext(context.Background(), sqlStr, args...)
+}
+
+// 查询单行，支持上下文
+// 错误在Scan时才能获取，查询钩子无法得到错误，需要记录错误时使用Query或QueryInto
+func (m *SqlDB) QueryRowContext(ctx context.Context, sqlStr string, args ...interface{}) *sql.Row {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	row := m.doQueryRow(ctx, sqlStr, args...)
+	m.afterQuery(ctx, event, -1, nil)
+	return row
+}
+
+// 查询单行并扫描到dest中，Scan完成后调用查询钩子
+// 没有记录时返回sql.ErrNoRows且不记录错误
+func (m *SqlDB) scanRow(ctx context.Context, sqlStr string, args []interface{}, dest ...interface{}) error {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	rows, err := m.doQuery(ctx, sqlStr, args...)
+	if err == nil {
+		err = scanFirst(rows, dest...)
+	}
+	if err == sql.ErrNoRows {
+		m.afterQuery(ctx, event, 0, nil)
+		return err
+	}
+	if err != nil {
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
+		return err
+	}
+	m.afterQuery(ctx, event, 1, nil)
+	return nil
+}
+
+// 扫描第一行并关闭查询结果，与sql.Row的Scan一致
+func scanFirst(rows *sql.Rows, dest ...interface{}) error {
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	return rows.Close()
 }
 
 // 获取所有数据
+// 根据字段类型将数据解码为int64、float64、Decimal、time.Time、bool、[]byte，NULL为nil
+// 开启stringResult时保持旧的行为，[]byte全部转换为字符串
 func (m *SqlDB) FetchAll(query *sql.Rows) ([]utils.M, error) {
-	columns, _ := query.Columns()
-	values := make([]interface{}, len(columns))
-	scans := make([]interface{}, len(columns))
-	for i := range values {
-		scans[i] = &values[i]
+	reader, err := m.newRowReader(query)
+	if err != nil {
+		return nil, err
 	}
 	results := make([]utils.M, 0)
 	for query.Next() {
-		if err := query.Scan(scans...); err != nil {
+		row, err := reader.read(query)
+		if err != nil {
 			return nil, err
 		}
-		row := utils.M{}
-		for k, v := range values {
-			key := columns[k]
-			switch v.(type) {
-			case []byte:
-				row[key] = string(v.([]byte))
-			default:
-				row[key] = v
-			}
-		}
 		results = append(results, row)
 	}
-	return results, nil
+	return results, query.Err()
 }
 
-// 执行SQL
-func (m *SqlDB) Exec(sqlStr string, args ...interface{}) (sql.Result, error) {
-	var res sql.Result
-	var err error
-	if m.tx != nil {
-		res, err = m.tx.Exec(sqlStr, args...)
-	} else {
-		res, err = m.db.Exec(sqlStr, args...)
-	}
-	m.LastSql = sqlStr
-	m.LastArgs = args
+// 按字段类型逐行读取查询结果
+type rowReader struct {
+	columns  []string
+	decoders []valueDecoder
+	values   []interface{}
+	scans    []interface{}
+}
+
+// 新建逐行读取器
+func (m *SqlDB) newRowReader(query *sql.Rows) (*rowReader, error) {
+	columns, err := query.Columns()
 	if err != nil {
-		if m.debug {
-			m.PrintError(err)
+		return nil, err
+	}
+	decoders := make([]valueDecoder, len(columns))
+	if m.stringResult {
+		for i := range decoders {
+			decoders[i] = decodeString
 		}
+	} else {
+		types, err := query.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		for i, ct := range types {
+			decoders[i] = columnDecoder(ct)
+		}
+	}
+	r := &rowReader{
+		columns:  columns,
+		decoders: decoders,
+		values:   make([]interface{}, len(columns)),
+		scans:    make([]interface{}, len(columns)),
+	}
+	for i := range r.values {
+		r.scans[i] = &r.values[i]
+	}
+	return r, nil
+}
+
+// 读取当前行
+func (r *rowReader) read(query *sql.Rows) (utils.M, error) {
+	if err := query.Scan(r.scans...); err != nil {
 		return nil, err
 	}
-	return res, err
+	row := make(utils.M, len(r.columns))
+	for k, v := range r.values {
+		key := r.columns[k]
+		switch v.(type) {
+		case []byte:
+			row[key] = r.decoders[k](v.([]byte))
+		default:
+			row[key] = v
+		}
+	}
+	return row, nil
 }
 
-// 打印错误
-func (m *SqlDB) PrintError(err error) {
-	fmt.Println(m.LastSql)
-	fmt.Println(m.LastArgs)
-	fmt.Println(err)
-	m.lastError = err
+// 设置查询结果是否保持字符串格式
+func (m *SqlDB) SetStringResult(stringResult bool) {
+	m.stringResult = stringResult
 }
 
-// 获取最后一条错误
-func (m *SqlDB) GetLastError() error {
-	return m.lastError
+// 执行SQL
+func (m *SqlDB) Exec(sqlStr string, args ...interface{}) (sql.Result, error) {
+	return m.ExecContext(context.Background(), sqlStr, args...)
 }
 
-// 处理where条件
-func (m *SqlDB) ProcessWhere(where utils.M, icon string, table string) (string, []interface{}) {
-	var whereStrings []string
-	var values []interface{}
-	for i, v := range where {
-		if i == "AND" || i == "OR" {
-			// 递归获取所有查询条件
-			tmpWhere, val := m.ProcessWhere(v.(utils.M), i, table)
-			whereStrings = append(whereStrings, tmpWhere)
-			values = append(values, val...)
-		} else {
-			t := reflect.TypeOf(v).Kind()
-			if t == reflect.Slice || t == reflect.Array {
-				values = append(values, v.([]interface{})...)
-				whereStrings = append(whereStrings, m.formatWhere(i, table, len(v.([]interface{}))))
-			} else {
-				values = append(values, v)
-				whereStrings = append(whereStrings, m.formatWhere(i, table, 0))
-			}
+// 执行SQL，支持上下文
+func (m *SqlDB) ExecContext(ctx context.Context, sqlStr string, args ...interface{}) (sql.Result, error) {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	lastLock.Lock()
+	m.LastSql = sqlStr
+	m.LastArgs = args
+	lastLock.Unlock()
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	res, err := m.doExec(ctx, sqlStr, args...)
+	if err != nil {
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
+		return nil, err
+	}
+	if event != nil {
+		affected, err := res.RowsAffected()
+		if err != nil {
+			affected = -1
 		}
+		m.afterQuery(ctx, event, affected, nil)
 	}
-	wherePrefix := fmt.Sprintf(" %s ", icon)
-	whereStr := fmt.Sprintf("(%s)", strings.Join(whereStrings, wherePrefix))
-	return whereStr, values
+	return res, nil
 }
 
-// 格式化where条件
-func (m *SqlDB) formatWhere(column string, table string, length int) string {
-	filed := m.explainColumn(column)
-
-	columnStr := m.FormatColumn(filed.Field)
-	icon := filed.Icon
-	var whereIcon string
-
-	var formatStr string
-	// 处理多个值的情况
-	if length > 0 {
-		var maskArgs []string
-		whereIcon = "IN"
-		for i := 0; i < length; i++ {
-			maskArgs = append(maskArgs, "?")
-		}
-		if icon == "!" {
-			whereIcon = "NOT IN"
-		}
-		formatStr = fmt.Sprintf("%s.%s %s (%s)", m.FormatColumn(table), columnStr, whereIcon, strings.Join(maskArgs, ","))
-	} else {
-		if icon == "!" {
-			whereIcon = "!="
-		} else {
-			whereIcon = icon
-		}
-		formatStr = fmt.Sprintf("%s.%s %v ?", m.FormatColumn(table), columnStr, whereIcon)
+// 获取当前的执行器，开启事务时使用事务执行
+func (m *SqlDB) executor() executor {
+	if m.tx != nil {
+		return m.tx
 	}
-	return formatStr
+	return m.db
 }
 
-// 解析字段
-func (m *SqlDB) explainColumn(column string) *DBColumn {
-	match := columnReg.FindStringSubmatch(column)
-	filed := &DBColumn{}
-	if len(match) > 0 {
-		filed.Field = match[1]
-		filed.Icon = match[2]
-	} else {
-		filed.Field = column
-		filed.Icon = "="
+// 记录错误，上下文取消或超时时返回上下文的错误
+func (m *SqlDB) handleError(ctx context.Context, err error) error {
+	err = m.contextError(ctx, err)
+	m.setLastError(err)
+	return err
+}
+
+// 上下文已结束时返回context.Canceled或context.DeadlineExceeded
+func (m *SqlDB) contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
 	}
-	return filed
+	return err
 }
 
-// 格式化字段
-func (m *SqlDB) FormatColumn(column string) string {
-	return fmt.Sprintf("`%s`", column)
+// 打印错误，SQL中的敏感参数会被隐藏
+// 已废弃，使用QueryLogger或AddHook记录SQL及错误
+func (m *SqlDB) PrintError(err error) {
+	lastLock.RLock()
+	sqlStr, args := m.LastSql, m.LastArgs
+	lastLock.RUnlock()
+	log.Println(InterpolateSql(sqlStr, redactArgs(sqlStr, args, defaultRedactColumns)))
+	log.Println(err)
+	m.setLastError(err)
 }
 
-// 扫描数据到map中
-func (m *SqlDB) scan2Map(scans []interface{}, columns []string) interface{} {
-	obj := utils.M{}
-	for i, v := range columns {
-		var val interface{}
-		obj[v] = val
-		scans[i] = &val
-	}
-	return obj
+// 获取最后一条错误
+func (m *SqlDB) GetLastError() error {
+	lastLock.RLock()
+	defer lastLock.RUnlock()
+	return m.lastError
 }
 
-// 扫描数据到结构体中
-func (m *SqlDB) scan2Struct(t reflect.Type, scans []interface{}, columns []string) interface{} {
-	obj := reflect.New(t).Interface()
-	objVal := reflect.ValueOf(obj).Elem()
-	for i, c := range columns {
-		index := m.findTagOf(t, c)
-		if index != -1 {
-			scans[i] = objVal.Field(index).Addr().Interface()
-		} else {
-			var empty interface{}
-			scans[i] = &empty
-		}
-	}
-	return obj
+// 记录最后一条错误
+func (m *SqlDB) setLastError(err error) {
+	lastLock.Lock()
+	m.lastError = err
+	lastLock.Unlock()
 }
 
-// 扫描数据到任何类型中
-func (m *SqlDB) scan2Any(scans []interface{}, columns []string, i interface{}) interface{} {
-	if i == nil {
-		return m.scan2Map(scans, columns)
-	}
-	t := reflect.TypeOf(i)
-	switch t.Kind() {
-	case reflect.Ptr:
-		return m.scan2Struct(t.Elem(), scans, columns)
-	case reflect.Struct:
-		return m.scan2Struct(t, scans, columns)
-	case reflect.Map:
-		fallthrough
-	default:
-		return m.scan2Map(scans, columns)
-	}
+// 复制SqlDB，用于事务及分表并发查询
+func (m *SqlDB) clone() *SqlDB {
+	lastLock.RLock()
+	db := *m
+	lastLock.RUnlock()
+	return &db
 }
 
-// 查找tag下标
-func (m *SqlDB) findTagOf(t reflect.Type, colName string) int {
-	for i := 0; i < t.NumField(); i++ {
-		val, ok := t.Field(i).Tag.Lookup("json")
-		if ok && val == colName {
-			return i
-		}
-	}
-	return -1
+// 格式化字段
+func (m *SqlDB) FormatColumn(column string) string {
+	return m.Dialect().Quote(column)
 }
 
 // 关闭数据库
 func (m *SqlDB) Close() {
+	if m.stmts != nil {
+		m.stmts.clear()
+	}
 	err := m.db.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if m.replicas != nil {
+		if err = m.replicas.close(); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 // 处理数据
@@ -409,56 +623,34 @@ func ConvertData(orgData interface{}) (DM, error) {
 			return DM(orgData.(utils.M)), nil
 		}
 		return orgData.(map[string]interface{}), nil
-	case reflect.Ptr:
-		fallthrough
-	case reflect.Struct:
-		return utils.Struct2Map(orgData, nil), nil
+	case reflect.Ptr, reflect.Struct:
+		v := reflect.Indirect(reflect.ValueOf(orgData))
+		if v.Kind() != reflect.Struct {
+			return nil, errors.New("not support this data")
+		}
+		return structData(v), nil
 	default:
 		return nil, errors.New("not support this data")
 	}
 }
 
-// 构建数据表结构体
+// 构建数据表结构体，输出表的结构体及操作代码，失败时输出错误日志
 func BuildTableStruct(tabName, dbName string, dbConf *DBConfig) {
-	types := map[string]string{
-		"int":      "int",
-		"tinyint":  "int",
-		"smallint": "int",
-		"varchar":  "string",
-		"char":     "string",
-		"text":     "string",
-		"tinytext": "string",
-		"double":   "float64",
-		"float":    "float64",
-	}
-
 	db, err := NewMysqlDB(dbConf)
 	if err != nil {
-		panic(err)
+		log.Printf("database: build table struct: %v", err)
+		return
 	}
-	res, err := db.Table("COLUMNS").Where(utils.M{"TABLE_NAME": tabName, "TABLE_SCHEMA": dbName}, "").Order(utils.M{"ORDINAL_POSITION": "ASC"}).Query().Result()
+	defer db.Close()
+	tables, err := db.LoadSchema(dbName, tabName)
 	if err != nil {
-		panic(err)
+		log.Printf("database: build table struct: %v", err)
+		return
 	}
-
-	var tmp []string
-
-	var (
-		colName    string
-		colType    string
-		colComment string
-	)
-
-	for _, row := range res {
-		colName = row["COLUMN_NAME"].(string)
-		colType = row["DATA_TYPE"].(string)
-		colComment = row["COLUMN_COMMENT"].(string)
-		tmp = append(tmp, fmt.Sprintf("\t%v %v `json:\"%v\" bson:\"%v\"` // %v", utils.Under2Hump(colName), types[colType], colName, colName, colComment))
-	}
-
-	fmt.Println(fmt.Sprintf("type %s struct {", tabName))
-	for _, v := range tmp {
-		fmt.Println(v)
+	code, err := GenerateCode(tables[0], nil)
+	if err != nil {
+		log.Printf("database: build table struct: %v", err)
+		return
 	}
-	fmt.Println("}")
+	fmt.Print(string(code))
 }
@@ -1,7 +1,13 @@
 package database
 
 import (
+	"context"
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 var dbConf = &DBConfig{
@@ -19,3 +25,95 @@ var dbConf = &DBConfig{
 func TestBuildTableStruct(t *testing.T) {
 	BuildTableStruct("t_agent", "pcbx_life_insurance", dbConf)
 }
+
+// 测试上下文超时
+func TestSqlDB_QueryContext(t *testing.T) {
+	db, fake := newFakeSqlDB("context", MysqlDialect{})
+	fake.setRows([]string{"id"}, []driver.Value{int64(1)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := db.QueryContext(ctx, "SELECT SLEEP(1)")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if db.Table("t_user").WithContext(ctx).Where(utils.M{"id": 1}, "").Update(utils.M{"SLEEP": 1}) {
+		t.Error("update should fail after deadline")
+	}
+	if db.GetLastError() != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", db.GetLastError())
+	}
+
+	list, err := db.Table("t_user").WithContext(context.Background()).Query().Result()
+	if err != nil || len(list) != 1 {
+		t.Errorf("query failed: %v %v", list, err)
+	}
+}
+
+// 测试多个协程同时使用同一个SqlDB，使用-race运行
+func TestSqlDB_Concurrent(t *testing.T) {
+	db, fake := newFakeSqlDB("concurrent", MysqlDialect{})
+	fake.setRows([]string{"id"}, []driver.Value{int64(1)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, _ = db.Query("SELECT id FROM t_user WHERE id = ?", i)
+				_, _ = db.Exec("UPDATE FAIL")
+				_ = db.Table("t_user").Where(utils.M{"id": i}, "").Rows()
+				_ = db.Table("t_user").Where(utils.M{"id": i}, "").Update(utils.M{"name": "a"})
+				var id int64
+				_ = db.QueryRow("SELECT id FROM t_user").Scan(&id)
+				_ = db.GetLastError()
+				_ = db.Transaction(func(tx *Tx) error {
+					_, err := tx.Exec("UPDATE t_user SET name = ?", "b")
+					return err
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := db.Exec("UPDATE FAIL"); err == nil || db.GetLastError() != err {
+		t.Errorf("expect last error recorded, got %v", err)
+	}
+}
+
+// 测试按字段类型解码查询结果
+func TestSqlDB_FetchAll(t *testing.T) {
+	db, fake := newFakeSqlDB("fetch_all", MysqlDialect{})
+	fake.setRows([]string{"id", "price", "rate", "created_at", "avatar", "name", "deleted_at"},
+		[]driver.Value{[]byte("12"), []byte("10.50"), []byte("0.5"), []byte("2020-01-02 03:04:05"), []byte{0xff, 0x01}, []byte("张三"), nil},
+	)
+	fake.setTypes("BIGINT", "DECIMAL", "DOUBLE", "DATETIME", "BLOB", "VARCHAR", "DATETIME")
+
+	list, err := db.Query("SELECT * FROM t_goods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := list[0]
+	expect := utils.M{
+		"id":         int64(12),
+		"price":      Decimal("10.50"),
+		"rate":       0.5,
+		"created_at": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"avatar":     []byte{0xff, 0x01},
+		"name":       "张三",
+		"deleted_at": nil,
+	}
+	if !reflect.DeepEqual(row, expect) {
+		t.Errorf("unexpected row: %#v", row)
+	}
+
+	// 兼容旧的字符串格式
+	db.SetStringResult(true)
+	list, _ = db.Query("SELECT * FROM t_goods")
+	if list[0]["id"] != "12" || list[0]["price"] != "10.50" {
+		t.Errorf("unexpected string row: %#v", list[0])
+	}
+}
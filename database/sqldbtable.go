@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"go_lib/utils"
 	"regexp"
@@ -25,18 +26,37 @@ type Join struct {
 
 // 表结构设置
 type DBTable struct {
-	where      utils.M // 条件
+	where      []utils.M // 每次调用Where的条件
 	whereStr   string
 	joinStr    string
 	groupStr   string        // 分组
+	havingStr  string        // 分组条件
+	havingVals []interface{} // 分组条件的值
 	fieldStr   string        // 字段
 	orderStr   string        // 排序
+	orders     []orderItem   // 排序字段，用于合并分表的查询结果
 	limitStr   string        // 分页
+	limitSize  int           // 每页数量
+	limitFrom  int           // 分页偏移量
 	sqlStr     string        // 执行的SQL语句
 	table      string        // 表名
 	values     []interface{} // 查询值
 	db         *SqlDB
 	columnType interface{} // 字段类型
+	pk         string      // 自增主键
+	ctx        context.Context
+	err        error       // 构建查询时的错误
+	primary    bool        // 本次查询强制使用主库
+	after      interface{} // 按主键分页时上一页最后一条记录的主键
+	trashed    int         // 软删除记录的查询范围
+	scoped     bool        // 是否已添加软删除条件
+	model      interface{} // 本次更新、删除操作的模型，用于调用钩子
+}
+
+// 排序字段
+type orderItem struct {
+	column string
+	desc   bool
 }
 
 // 验证字段正则
@@ -44,22 +64,42 @@ var fieldReg = regexp.MustCompile(`(.+?)\[(.+?)\]`)
 
 // 新建一个table处理类
 func NewDBTable(db *SqlDB, table string) *DBTable {
-	return &DBTable{table: table, db: db, fieldStr: "*"}
+	return &DBTable{table: table, db: db, fieldStr: "*", pk: db.primaryKey(table)}
 }
 
-// 开启事务
-func (t *DBTable) BeginTrans() error {
-	return t.db.BeginTrans()
+// 设置自增主键，用于不支持LastInsertId的数据库获取新增ID，为空时表示没有自增主键
+func (t *DBTable) PrimaryKey(column string) *DBTable {
+	t.pk = column
+	return t
+}
+
+// 设置上下文，后续的查询、新增、更新、删除操作都使用该上下文
+func (t *DBTable) WithContext(ctx context.Context) *DBTable {
+	t.ctx = ctx
+	return t
+}
+
+// 获取上下文
+func (t *DBTable) context() context.Context {
+	ctx := t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if t.primary {
+		ctx = UsePrimary(ctx)
+	}
+	return ctx
 }
 
-// 提交事务
-func (t *DBTable) Commit() error {
-	return t.db.Commit()
+// 本次查询使用主库，用于写入后立即读取
+func (t *DBTable) UsePrimary() *DBTable {
+	t.primary = true
+	return t
 }
 
-// 回滚事务
-func (t *DBTable) Rollback() error {
-	return t.db.Rollback()
+// 开启事务
+func (t *DBTable) BeginTrans() (*Tx, error) {
+	return t.db.BeginTrans()
 }
 
 // 解释字段
@@ -89,7 +129,8 @@ func (t *DBTable) explainField(field string) *Field {
 func (t *DBTable) Select(fields utils.M) *DBTable {
 	var tmp []string
 	var table string
-	for column, tName := range fields {
+	for _, column := range sortedKeys(fields) {
+		tName := fields[column]
 		if tName.(string) == "" {
 			table = t.db.FormatColumn(t.table)
 		} else {
@@ -104,9 +145,9 @@ func (t *DBTable) Select(fields utils.M) *DBTable {
 			}
 		} else {
 			if field.Func == "" {
-				tmp = append(tmp, fmt.Sprintf("%s.%s AS `%s`", table, t.db.FormatColumn(field.Column), field.Alias))
+				tmp = append(tmp, fmt.Sprintf("%s.%s AS %s", table, t.db.FormatColumn(field.Column), t.db.FormatColumn(field.Alias)))
 			} else {
-				tmp = append(tmp, fmt.Sprintf("%s(%s.%s) AS `%s`", field.Func, table, t.db.FormatColumn(field.Column), field.Alias))
+				tmp = append(tmp, fmt.Sprintf("%s(%s.%s) AS %s", field.Func, table, t.db.FormatColumn(field.Column), t.db.FormatColumn(field.Alias)))
 			}
 		}
 	}
@@ -116,13 +157,21 @@ func (t *DBTable) Select(fields utils.M) *DBTable {
 
 // 设置where条件
 func (t *DBTable) Where(fields utils.M, table string) *DBTable {
-	t.where = fields
+	t.where = append(t.where, fields)
 	if table == "" {
 		table = t.table
 	}
 
-	whereStr, val := t.db.ProcessWhere(fields, "AND", table)
-	t.whereStr += whereStr
+	whereStr, val, err := t.db.buildWhere(fields, "AND", table)
+	if err != nil {
+		t.err = err
+		return t
+	}
+	if t.whereStr == "" {
+		t.whereStr = whereStr
+	} else {
+		t.whereStr += " AND " + whereStr
+	}
 	t.values = append(t.values, val...)
 	return t
 }
@@ -154,93 +203,373 @@ func (t *DBTable) JoinOne(join *Join) *DBTable {
 	return t
 }
 
-// 设置order排序
+// 设置分组字段，可以使用table.column指定表名
+func (t *DBTable) Group(columns ...string) *DBTable {
+	var tmp []string
+	for _, c := range columns {
+		table := t.table
+		if i := strings.Index(c, "."); i != -1 {
+			table, c = c[:i], c[i+1:]
+		}
+		tmp = append(tmp, fmt.Sprintf("%s.%s", t.db.FormatColumn(table), t.db.FormatColumn(c)))
+	}
+	t.groupStr = "GROUP BY " + strings.Join(tmp, ",")
+	return t
+}
+
+// 设置分组条件，语法与Where一致，字段不添加表名
+// 可以使用聚合函数写法，如 utils.M{"id[count][>]": 1} => COUNT(`id`) > 1
+func (t *DBTable) Having(fields utils.M) *DBTable {
+	havingStr, val, err := t.db.buildWhere(fields, "AND", "")
+	if err != nil {
+		t.err = err
+		return t
+	}
+	if t.havingStr == "" {
+		t.havingStr = havingStr
+	} else {
+		t.havingStr += " AND " + havingStr
+	}
+	t.havingVals = append(t.havingVals, val...)
+	return t
+}
+
+// 设置order排序，map无序，多个排序字段按字段名排列，需要指定顺序时使用OrderBy
 func (t *DBTable) Order(orders utils.M) *DBTable {
 	var tmp []string
-	for c, v := range orders {
-		field := t.explainField(c)
-		if field.Alias != "" {
-			tmp = append(tmp, fmt.Sprintf("%s.%s %s", t.db.FormatColumn(field.Alias), t.db.FormatColumn(field.Column), v))
-		} else {
-			tmp = append(tmp, fmt.Sprintf("%s.%s %s", t.db.FormatColumn(t.table), t.db.FormatColumn(field.Column), v))
+	t.orders = nil
+	for _, c := range sortedKeys(orders) {
+		tmp = append(tmp, t.orderColumn(c, orders[c]))
+	}
+	t.orderStr = "ORDER BY " + strings.Join(tmp, ",")
+	return t
+}
 
+// 按顺序设置排序，如 OrderBy("age DESC", "id")
+func (t *DBTable) OrderBy(orders ...string) *DBTable {
+	var tmp []string
+	t.orders = nil
+	for _, o := range orders {
+		parts := strings.Fields(o)
+		if len(parts) == 0 {
+			continue
+		}
+		sort := "ASC"
+		if len(parts) > 1 {
+			sort = strings.ToUpper(parts[1])
+		}
+		if sort != "ASC" && sort != "DESC" {
+			t.err = fmt.Errorf("database: invalid order %s", o)
+			return t
 		}
+		tmp = append(tmp, t.orderColumn(parts[0], sort))
+	}
+	if len(tmp) > 0 {
+		t.orderStr = "ORDER BY " + strings.Join(tmp, ",")
 	}
-	t.orderStr = "ORDER BY " + strings.Join(tmp, ",")
 	return t
 }
 
+// 生成单个排序字段
+func (t *DBTable) orderColumn(c string, sort interface{}) string {
+	field := t.explainField(c)
+	t.orders = append(t.orders, orderItem{column: field.Column, desc: strings.ToUpper(fmt.Sprint(sort)) == "DESC"})
+	if field.Alias != "" {
+		return fmt.Sprintf("%s.%s %s", t.db.FormatColumn(field.Alias), t.db.FormatColumn(field.Column), sort)
+	}
+	return fmt.Sprintf("%s.%s %s", t.db.FormatColumn(t.table), t.db.FormatColumn(field.Column), sort)
+}
+
 // 设置分页
 func (t *DBTable) Limit(pageSize int, page int) *DBTable {
-	if page == 0 {
-		t.limitStr = fmt.Sprintf("LIMIT %d", pageSize)
-	} else {
-		currentNum := 0
-		if page > 1 {
-			currentNum = (page - 1) * pageSize
-		}
-		t.limitStr = fmt.Sprintf("LIMIT %d,%d", currentNum, pageSize)
+	currentNum := 0
+	if page > 1 {
+		currentNum = (page - 1) * pageSize
 	}
+	t.limitStr = t.db.Dialect().Limit(pageSize, currentNum)
+	t.limitSize = pageSize
+	t.limitFrom = currentNum
 	return t
 }
 
 // 新增
 func (t *DBTable) Insert(data interface{}) (int, bool) {
-	return t.db.Insert(t.table, data)
+	if err := t.db.beforeInsert(t.context(), data); err != nil {
+		t.db.setLastError(err)
+		return 0, false
+	}
+	dm, err := t.db.insertTimestamps(t.table, data)
+	if err != nil {
+		t.db.setLastError(err)
+		return 0, false
+	}
+	db, table, err := t.locate(dm)
+	if err != nil {
+		t.db.setLastError(err)
+		return 0, false
+	}
+	id, err := db.insert(t.context(), table, dm, t.pk)
+	if err != nil {
+		if db != t.db {
+			t.db.setLastError(err)
+		}
+		return id, false
+	}
+	if err = t.db.afterInsert(t.context(), data); err != nil {
+		t.db.setLastError(err)
+		return id, false
+	}
+	return id, true
 }
 
-// 删除
+// 批量新增，分表时按分片键拆分到各个分片
+func (t *DBTable) InsertMany(rows interface{}, opts ...*InsertManyOptions) (*InsertManyResult, error) {
+	if err := t.db.beforeInsert(t.context(), rows); err != nil {
+		t.db.setLastError(err)
+		return nil, err
+	}
+	var (
+		result *InsertManyResult
+		err    error
+	)
+	if rule := t.db.shardRule(t.table); rule != nil {
+		result, err = t.shardInsertMany(rule, rows, opts...)
+	} else {
+		result, err = t.db.insertMany(t.context(), t.table, rows, t.pk, opts...)
+	}
+	if err == nil {
+		if err = t.db.afterInsert(t.context(), rows); err != nil {
+			t.db.setLastError(err)
+		}
+	}
+	return result, err
+}
+
+// 插入更新，conflictKeys为空时使用主键
+func (t *DBTable) Upsert(data interface{}, updateColumns []string, conflictKeys ...string) (UpsertState, error) {
+	if len(conflictKeys) == 0 && t.pk != "" {
+		conflictKeys = []string{t.pk}
+	}
+	if err := t.db.beforeInsert(t.context(), data); err != nil {
+		t.db.setLastError(err)
+		return UpsertNone, err
+	}
+	dm, err := t.db.insertTimestamps(t.table, data)
+	if err != nil {
+		t.db.setLastError(err)
+		return UpsertNone, err
+	}
+	db, table, err := t.locate(dm)
+	if err != nil {
+		t.db.setLastError(err)
+		return UpsertNone, err
+	}
+	state, err := db.upsert(t.context(), table, dm, t.db.upsertTimestamps(t.table, updateColumns, data), conflictKeys)
+	if err == nil {
+		err = t.db.afterInsert(t.context(), data)
+	}
+	if err != nil {
+		t.db.setLastError(err)
+	}
+	return state, err
+}
+
+// 删除，软删除的表更新删除时间
+// 通过Model设置的模型实现了BeforeDeleteHook时在删除前调用
 func (t *DBTable) Delete() bool {
-	defer t.Clear()
-	_, err := t.db.Delete(t.where, t.table)
-	return err == nil
+	column := t.db.softDeleteColumn(t.table)
+	if column == "" {
+		return t.ForceDelete()
+	}
+	if err := t.db.beforeDelete(t.context(), t.model); err != nil {
+		t.db.setLastError(err)
+		t.Clear()
+		return false
+	}
+	return t.update(utils.M{column: t.db.now(t.table)})
 }
 
-// 更新
+// 更新，通过Model设置的模型实现了BeforeUpdateHook、AfterUpdateHook时在更新前后调用
 func (t *DBTable) Update(data utils.M) bool {
+	model := t.model
+	if err := t.db.beforeUpdate(t.context(), model); err != nil {
+		t.db.setLastError(err)
+		t.Clear()
+		return false
+	}
+	ctx := t.context()
+	if !t.update(data) {
+		return false
+	}
+	if err := t.db.afterUpdate(ctx, model); err != nil {
+		t.db.setLastError(err)
+		return false
+	}
+	return true
+}
+
+// 更新，不调用模型的钩子
+func (t *DBTable) update(data utils.M) bool {
 	defer t.Clear()
-	err := t.db.Update(data, t.where, t.table)
-	return err == nil
+	t.applySoftDelete()
+	if t.checkError() != nil {
+		return false
+	}
+	data = t.db.updateTimestamps(t.table, data, t.model)
+	return t.eachShard(func(db *SqlDB, table string) error {
+		_, err := db.update(t.context(), data, t.where, table)
+		return err
+	})
 }
 
 // 查询操作
 func (t *DBTable) Query() *DBTable {
-	var whereStr string
-	if t.whereStr != "" {
-		whereStr = "WHERE " + t.whereStr
-	}
-	t.sqlStr = fmt.Sprintf("SELECT %s FROM %s %s %s %s %s",
+	t.applySoftDelete()
+	t.sqlStr = t.selectSql(t.db.FormatColumn(t.table), t.limitStr)
+	return t
+}
+
+// 生成查询语句，from为查询的表
+func (t *DBTable) selectSql(from string, limitStr string) string {
+	return fmt.Sprintf("SELECT %s FROM %s %s %s %s %s",
 		t.fieldStr,
-		t.db.FormatColumn(t.table),
+		from,
 		t.joinStr,
-		whereStr,
+		t.conditionStr(),
 		t.orderStr,
-		t.limitStr,
+		limitStr,
 	)
-	return t
 }
 
-// 获取查询记录条数
-func (t *DBTable) Rows() int {
-	var whereStr string
+// 生成WHERE、GROUP BY、HAVING语句
+func (t *DBTable) conditionStr() string {
+	var tmp []string
 	if t.whereStr != "" {
-		whereStr = "WHERE " + t.whereStr
+		tmp = append(tmp, "WHERE "+t.whereStr)
+	}
+	if t.groupStr != "" {
+		tmp = append(tmp, t.groupStr)
+		if t.havingStr != "" {
+			tmp = append(tmp, "HAVING "+t.havingStr)
+		}
 	}
-	sqlStr := fmt.Sprintf("SELECT count(*) FROM %s %s %s", t.db.FormatColumn(t.table), t.joinStr, whereStr)
+	return strings.Join(tmp, " ")
+}
 
-	row := t.db.QueryRow(sqlStr, t.values...)
-	var count int
-	err := row.Scan(&count)
-	if err != nil {
-		return 0
+// 获取查询参数，WHERE的参数在前，HAVING的参数在后
+func (t *DBTable) queryArgs() []interface{} {
+	if t.groupStr == "" || len(t.havingVals) == 0 {
+		return t.values
 	}
+	args := make([]interface{}, 0, len(t.values)+len(t.havingVals))
+	args = append(args, t.values...)
+	return append(args, t.havingVals...)
+}
+
+// 获取查询记录条数，分组查询时返回分组的数量，查询后清除查询条件
+func (t *DBTable) Rows() int {
+	defer t.Clear()
+	count, _ := t.total()
 	return count
 }
 
+// 获取查询记录条数，不清除查询条件
+func (t *DBTable) total() (int, error) {
+	t.applySoftDelete()
+	if err := t.checkError(); err != nil {
+		return 0, err
+	}
+	shards, err := t.shards()
+	if err != nil {
+		t.db.setLastError(err)
+		return 0, err
+	}
+	if shards == nil {
+		return t.count(t.db, t.db.FormatColumn(t.table))
+	}
+	return t.shardRows(shards)
+}
+
+// 在db上查询记录条数，from为查询的表
+func (t *DBTable) count(db *SqlDB, from string) (int, error) {
+	var sqlStr string
+	if t.groupStr != "" {
+		sqlStr = fmt.Sprintf("SELECT count(*) FROM (SELECT 1 FROM %s %s %s) %s", from, t.joinStr, t.conditionStr(), t.db.FormatColumn("t_count"))
+	} else {
+		sqlStr = fmt.Sprintf("SELECT count(*) FROM %s %s %s", from, t.joinStr, t.conditionStr())
+	}
+
+	var count int
+	if err := db.scanRow(t.context(), sqlStr, t.queryArgs(), &count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 生成子查询语句及参数
+func (t *DBTable) subQuery() (string, []interface{}, error) {
+	if t.err != nil {
+		return "", nil, t.err
+	}
+	if t.sqlStr == "" {
+		t.Query()
+	}
+	return strings.TrimSpace(t.sqlStr), t.queryArgs(), nil
+}
+
+// 获取构建查询时的错误
+func (t *DBTable) Error() error {
+	return t.err
+}
+
+// 存在构建错误时记录到SqlDB中，可通过GetLastError获取
+func (t *DBTable) checkError() error {
+	if t.err != nil {
+		t.db.setLastError(t.err)
+	}
+	return t.err
+}
+
 // 返回查询结果
 func (t *DBTable) Result() ([]utils.M, error) {
 	defer t.Clear()
-	return t.db.Query(t.sqlStr, t.values...)
+	if err := t.checkError(); err != nil {
+		return nil, err
+	}
+	shards, err := t.shards()
+	if err != nil {
+		t.db.setLastError(err)
+		return nil, err
+	}
+	if shards != nil {
+		return t.shardResult(shards)
+	}
+	return t.db.QueryContext(t.context(), t.sqlStr, t.queryArgs()...)
+}
+
+// 查询并将结果扫描到结构体切片中
+func (t *DBTable) Find(dest interface{}) error {
+	defer t.Clear()
+	if err := t.checkError(); err != nil {
+		return err
+	}
+	if t.sqlStr == "" {
+		t.Query()
+	}
+	shards, err := t.shards()
+	if err != nil {
+		t.db.setLastError(err)
+		return err
+	}
+	if shards != nil {
+		return t.shardFind(shards, dest)
+	}
+	return t.db.QueryIntoContext(t.context(), dest, t.sqlStr, t.queryArgs()...)
+}
+
+// 查询第一条记录并扫描到结构体中，没有记录时返回sql.ErrNoRows
+func (t *DBTable) First(dest interface{}) error {
+	return t.Limit(1, 0).Query().Find(dest)
 }
 
 // 清除查询条件
@@ -248,10 +577,21 @@ func (t *DBTable) Clear() {
 	t.whereStr = ""
 	t.joinStr = ""
 	t.groupStr = ""
-	t.fieldStr = ""
+	t.havingStr = ""
+	t.havingVals = nil
+	t.fieldStr = "*"
 	t.orderStr = ""
+	t.orders = nil
 	t.limitStr = ""
+	t.limitSize = 0
+	t.limitFrom = 0
 	t.sqlStr = ""
 	t.where = nil
 	t.values = []interface{}{}
+	t.err = nil
+	t.primary = false
+	t.after = nil
+	t.trashed = trashedExclude
+	t.scoped = false
+	t.model = nil
 }
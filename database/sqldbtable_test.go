@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+)
+
+// 测试分组查询
+func TestDBTable_Group(t *testing.T) {
+	db, fake := newFakeSqlDB("group", MysqlDialect{})
+	fake.setRows([]string{"count(*)"}, []driver.Value{int64(3)})
+
+	table := db.Table("t_order")
+	build := func() *DBTable {
+		return table.Having(utils.M{"id[count][>]": 1}).
+			Where(utils.M{"status": 1}, "").
+			Group("user_id")
+	}
+	if rows := build().Rows(); rows != 3 {
+		t.Errorf("unexpected rows: %d", rows)
+	}
+	_, _ = build().Select(utils.M{"user_id": "", "id total[count]": ""}).Query().Result()
+
+	log := fake.log()
+	expect := []string{
+		"SELECT count(*) FROM (SELECT 1 FROM `t_order`  WHERE (`t_order`.`status` = ?) GROUP BY `t_order`.`user_id` HAVING (COUNT(`id`) > ?)) `t_count`",
+		"SELECT COUNT(`t_order`.`id`) AS `total`,`t_order`.`user_id` FROM `t_order`  WHERE (`t_order`.`status` = ?) GROUP BY `t_order`.`user_id` HAVING (COUNT(`id`) > ?)  ",
+	}
+	if log[0] != expect[0] {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if log[1] != expect[1] {
+		t.Errorf("unexpected sql: %s", log[1])
+	}
+	if !reflect.DeepEqual(fake.args[1], []driver.Value{int64(1), int64(1)}) {
+		t.Errorf("unexpected args: %v", fake.args[1])
+	}
+}
+
+// 测试子查询条件
+func TestDBTable_SubQuery(t *testing.T) {
+	db, fake := newFakeSqlDB("sub_query", PostgresDialect{})
+
+	vip := db.Table("t_vip").Select(utils.M{"user_id": ""}).Where(utils.M{"level[>=]": 3}, "")
+	order := db.Table("t_order").Select(utils.M{"id": ""}).Where(utils.M{"status": 2}, "")
+	_, _ = db.Table("t_user").
+		Where(utils.M{"age[>]": 18}, "").
+		Where(utils.M{"id": vip}, "").
+		Where(utils.M{"NOT EXISTS": order}, "").
+		Query().Result()
+
+	expect := `SELECT * FROM "t_user"  WHERE ("t_user"."age" > $1) AND ("t_user"."id" IN (SELECT "t_vip"."user_id" FROM "t_vip"  WHERE ("t_vip"."level" >= $2))) AND (NOT EXISTS (SELECT "t_order"."id" FROM "t_order"  WHERE ("t_order"."status" = $3)))  `
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if !reflect.DeepEqual(fake.args[0], []driver.Value{int64(18), int64(3), int64(2)}) {
+		t.Errorf("unexpected args: %v", fake.args[0])
+	}
+
+	// 比较子查询
+	maxAge := db.Table("t_user").Select(utils.M{"age[max]": ""})
+	str, _ := db.ProcessWhere(utils.M{"age[=]": maxAge}, "AND", "t_user")
+	if str != `("t_user"."age" = (SELECT MAX("t_user"."age") FROM "t_user"))` {
+		t.Errorf("unexpected where: %s", str)
+	}
+}
+
+// 测试多次调用Where时条件之间使用AND连接
+func TestDBTable_WhereMultiple(t *testing.T) {
+	db, fake := newFakeSqlDB("where_multiple", MysqlDialect{})
+
+	build := func() *DBTable {
+		return db.Table("t_user").
+			Where(utils.M{"id": 1}, "").
+			Where(utils.M{"status": 2}, "").
+			Where(utils.M{"OR": utils.M{"age[>]": 3, "name": "a"}}, "")
+	}
+	build().Update(utils.M{"name": "b"})
+	build().Delete()
+
+	where := "WHERE (`t_user`.`id` = ?) AND (`t_user`.`status` = ?) AND ((`t_user`.`age` > ? OR `t_user`.`name` = ?))"
+	expect := []string{
+		"UPDATE `t_user` SET `name` = ? " + where,
+		"DELETE FROM `t_user` " + where,
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+	if !reflect.DeepEqual(fake.args[1], []driver.Value{int64(1), int64(2), int64(3), "a"}) {
+		t.Errorf("unexpected args: %v", fake.args[1])
+	}
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 字段引用验证规则，如 qty、b.min_qty
+var colReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQL表达式，作为Where、Update、Insert的值时直接拼接到语句中，不作为参数绑定
+type Expr struct {
+	sql    string
+	args   []interface{}
+	column string
+}
+
+// 原生SQL表达式，args按顺序绑定到表达式中的?占位符
+// 如 Raw("NOW()")、Raw("price * ?", 1.1)
+func Raw(sqlStr string, args ...interface{}) *Expr {
+	return &Expr{sql: sqlStr, args: args}
+}
+
+// 引用另一个字段，可以使用table.column指定表名
+// 如 Col("b.min_qty")
+func Col(column string) *Expr {
+	return &Expr{column: column}
+}
+
+// 生成表达式语句
+func (m *SqlDB) exprSql(e *Expr) (string, []interface{}, error) {
+	if e.column == "" {
+		return e.sql, e.args, nil
+	}
+	if !colReg.MatchString(e.column) {
+		return "", nil, fmt.Errorf("database: invalid column reference %s", e.column)
+	}
+	parts := strings.Split(e.column, ".")
+	return strings.Join(quoteColumns(m.Dialect(), parts), "."), nil, nil
+}
+
+// 生成值的占位符，表达式直接拼接，其他值使用?
+func (m *SqlDB) valueSql(v interface{}) (string, []interface{}, error) {
+	if e, ok := v.(*Expr); ok {
+		return m.exprSql(e)
+	}
+	return "?", []interface{}{v}, nil
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+)
+
+// 测试原生表达式及字段引用
+func TestSqlDB_Expr(t *testing.T) {
+	db, fake := newFakeSqlDB("expr", MysqlDialect{})
+
+	err := db.Update(utils.M{"price": Raw("price * ?", 1.1), "updated_at": Raw("NOW()")}, utils.M{"qty[>]": Col("b.min_qty")}, "t_goods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := fake.log()
+	if log[0] != "UPDATE `t_goods` SET `price` = price * ?,`updated_at` = NOW() WHERE (`t_goods`.`qty` > `b`.`min_qty`)" {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if !reflect.DeepEqual(fake.args[0], []driver.Value{1.1}) {
+		t.Errorf("unexpected args: %v", fake.args[0])
+	}
+
+	if _, ok := db.Insert("t_goods", utils.M{"created_at": Raw("NOW()")}); !ok {
+		t.Fatal(db.GetLastError())
+	}
+	if log = fake.log(); log[1] != "INSERT INTO `t_goods`(`created_at`) VALUES(NOW())" {
+		t.Errorf("unexpected sql: %s", log[1])
+	}
+
+	// 不合法的字段引用
+	if _, _, err = db.buildWhere(utils.M{"qty": Col("min_qty; DROP")}, "AND", "t_goods"); err == nil {
+		t.Error("invalid column reference should be rejected")
+	}
+}
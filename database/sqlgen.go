@@ -0,0 +1,422 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/format"
+	"go_lib/utils"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 可空字段的生成方式
+const (
+	GenNullPointer = "pointer" // 使用指针，如 *string
+	GenNullSql     = "sql"     // 使用sql.Null*，没有对应类型时使用指针
+)
+
+// 数据表字段信息
+type SchemaColumn struct {
+	Name          string
+	DataType      string // 数据类型，如 varchar、bigint
+	ColumnType    string // 完整类型，如 bigint(20) unsigned、tinyint(1)
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	Comment       string
+}
+
+// 数据表信息
+type SchemaTable struct {
+	Name    string
+	Comment string
+	Columns []*SchemaColumn
+}
+
+// 获取主键字段
+func (t *SchemaTable) PrimaryKeys() []*SchemaColumn {
+	var keys []*SchemaColumn
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			keys = append(keys, c)
+		}
+	}
+	return keys
+}
+
+// 代码生成选项
+type GenOptions struct {
+	Package    string // 包名，默认model
+	NullStyle  string // 可空字段的生成方式，默认pointer
+	TrimPrefix string // 生成结构体名时去掉的表名前缀，如 t_
+}
+
+// 通过INFORMATION_SCHEMA读取数据库的表结构，tables为空时读取全部表
+func (m *SqlDB) LoadSchema(schema string, tables ...string) ([]*SchemaTable, error) {
+	return m.LoadSchemaContext(context.Background(), schema, tables...)
+}
+
+// 读取表结构，支持上下文
+func (m *SqlDB) LoadSchemaContext(ctx context.Context, schema string, tables ...string) ([]*SchemaTable, error) {
+	list, err := m.loadSchema(ctx, schema, tables...)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) > len(list) {
+		exist := make(map[string]bool, len(list))
+		for _, t := range list {
+			exist[t.Name] = true
+		}
+		for _, t := range tables {
+			if !exist[t] {
+				return nil, fmt.Errorf("database: table %s not found in %s", t, schema)
+			}
+		}
+	}
+	return list, nil
+}
+
+// 读取表结构，不存在的表忽略
+func (m *SqlDB) loadSchema(ctx context.Context, schema string, tables ...string) ([]*SchemaTable, error) {
+	var columnSql, tableSql string
+	switch m.Dialect().Name() {
+	case "mysql":
+		columnSql = "SELECT TABLE_NAME AS table_name, COLUMN_NAME AS column_name, DATA_TYPE AS data_type, COLUMN_TYPE AS column_type, " +
+			"IS_NULLABLE AS is_nullable, COLUMN_KEY AS column_key, EXTRA AS extra, COLUMN_COMMENT AS column_comment " +
+			"FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME, ORDINAL_POSITION"
+		tableSql = "SELECT TABLE_NAME AS table_name, TABLE_COMMENT AS table_comment FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE'"
+	case "postgres":
+		columnSql = "SELECT c.table_name, c.column_name, c.data_type, " +
+			"CASE WHEN c.character_maximum_length IS NULL THEN c.udt_name ELSE c.udt_name || '(' || c.character_maximum_length || ')' END AS column_type, c.is_nullable, " +
+			"CASE WHEN k.column_name IS NULL THEN '' ELSE 'PRI' END AS column_key, " +
+			"CASE WHEN c.column_default LIKE 'nextval(%' OR c.is_identity = 'YES' THEN 'auto_increment' ELSE '' END AS extra, '' AS column_comment " +
+			"FROM information_schema.columns c LEFT JOIN (SELECT kcu.table_name, kcu.column_name FROM information_schema.table_constraints tc " +
+			"JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema " +
+			"WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = ?) k ON k.table_name = c.table_name AND k.column_name = c.column_name " +
+			"WHERE c.table_schema = ? ORDER BY c.table_name, c.ordinal_position"
+		tableSql = "SELECT table_name, '' AS table_comment FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE'"
+	case "sqlite3":
+		return m.loadSqliteSchema(ctx, tables...)
+	default:
+		return nil, fmt.Errorf("database: LoadSchema does not support %s", m.Dialect().Name())
+	}
+
+	args := []interface{}{schema}
+	if strings.Count(columnSql, "?") == 2 {
+		args = append(args, schema)
+	}
+	rows, err := m.QueryContext(ctx, columnSql, args...)
+	if err != nil {
+		return nil, err
+	}
+	tableRows, err := m.QueryContext(ctx, tableSql, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		filter[t] = true
+	}
+	var list []*SchemaTable
+	index := make(map[string]*SchemaTable)
+	for _, row := range tableRows {
+		name := schemaString(row["table_name"])
+		if _, ok := index[name]; ok || len(filter) > 0 && !filter[name] {
+			continue
+		}
+		table := &SchemaTable{Name: name, Comment: schemaString(row["table_comment"])}
+		index[name] = table
+		list = append(list, table)
+	}
+	for _, row := range rows {
+		table, ok := index[schemaString(row["table_name"])]
+		if !ok {
+			continue
+		}
+		table.Columns = append(table.Columns, &SchemaColumn{
+			Name:          schemaString(row["column_name"]),
+			DataType:      strings.ToLower(schemaString(row["data_type"])),
+			ColumnType:    strings.ToLower(schemaString(row["column_type"])),
+			Nullable:      strings.ToUpper(schemaString(row["is_nullable"])) == "YES",
+			PrimaryKey:    schemaString(row["column_key"]) == "PRI",
+			AutoIncrement: strings.Contains(strings.ToLower(schemaString(row["extra"])), "auto_increment"),
+			Comment:       schemaString(row["column_comment"]),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// 读取sqlite的表结构，sqlite没有INFORMATION_SCHEMA，通过PRAGMA获取字段
+func (m *SqlDB) loadSqliteSchema(ctx context.Context, tables ...string) ([]*SchemaTable, error) {
+	tableRows, err := m.QueryContext(ctx, "SELECT name AS table_name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	filter := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		filter[t] = true
+	}
+	var list []*SchemaTable
+	for _, row := range tableRows {
+		name := schemaString(row["table_name"])
+		if len(filter) > 0 && !filter[name] {
+			continue
+		}
+		rows, err := m.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", m.FormatColumn(name)))
+		if err != nil {
+			return nil, err
+		}
+		table := &SchemaTable{Name: name}
+		for _, r := range rows {
+			columnType := strings.ToLower(schemaString(r["type"]))
+			dataType := columnType
+			if i := strings.IndexByte(dataType, '('); i != -1 {
+				dataType = dataType[:i]
+			}
+			pk := schemaString(r["pk"]) != "0"
+			table.Columns = append(table.Columns, &SchemaColumn{
+				Name:          schemaString(r["name"]),
+				DataType:      dataType,
+				ColumnType:    columnType,
+				Nullable:      schemaString(r["notnull"]) == "0" && !pk,
+				PrimaryKey:    pk,
+				AutoIncrement: pk && dataType == "integer",
+			})
+		}
+		list = append(list, table)
+	}
+	return list, nil
+}
+
+// 转换为字符串
+func schemaString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(val)
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
+
+// 获取字段对应的Go类型及需要引入的包
+func GoType(column *SchemaColumn, nullStyle string) (string, string) {
+	typ, pkg := baseGoType(column)
+	if !column.Nullable || typ == "[]byte" {
+		return typ, pkg
+	}
+	if nullStyle == GenNullSql {
+		switch typ {
+		case "string":
+			return "sql.NullString", "database/sql"
+		case "int64":
+			return "sql.NullInt64", "database/sql"
+		case "float64":
+			return "sql.NullFloat64", "database/sql"
+		case "bool":
+			return "sql.NullBool", "database/sql"
+		}
+	}
+	return "*" + typ, pkg
+}
+
+// 获取非空字段的Go类型
+func baseGoType(column *SchemaColumn) (string, string) {
+	unsigned := strings.Contains(column.ColumnType, "unsigned")
+	switch column.DataType {
+	case "tinyint":
+		if strings.HasPrefix(column.ColumnType, "tinyint(1)") {
+			return "bool", ""
+		}
+		if unsigned {
+			return "uint8", ""
+		}
+		return "int8", ""
+	case "smallint", "int2", "smallserial":
+		if unsigned {
+			return "uint16", ""
+		}
+		return "int16", ""
+	case "mediumint", "int", "integer", "int4", "serial":
+		if unsigned {
+			return "uint32", ""
+		}
+		return "int32", ""
+	case "bigint", "int8", "bigserial":
+		if unsigned {
+			return "uint64", ""
+		}
+		return "int64", ""
+	case "year":
+		return "int16", ""
+	case "bit":
+		if strings.HasPrefix(column.ColumnType, "bit(1)") {
+			return "bool", ""
+		}
+		return "[]byte", ""
+	case "bool", "boolean":
+		return "bool", ""
+	case "float", "real", "float4":
+		return "float32", ""
+	case "double", "double precision", "float8":
+		return "float64", ""
+	case "decimal", "numeric", "money":
+		return "database.Decimal", "go_lib/database"
+	case "date", "datetime", "timestamp", "timestamptz", "timestamp without time zone", "timestamp with time zone":
+		return "time.Time", "time"
+	case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob", "bytea", "geometry", "point", "linestring", "polygon":
+		return "[]byte", ""
+	}
+	// char、varchar、text、enum、set、json、time、uuid等
+	return "string", ""
+}
+
+// 生成单个表的代码
+func GenerateCode(table *SchemaTable, opts *GenOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &GenOptions{}
+	}
+	pkgName := opts.Package
+	if pkgName == "" {
+		pkgName = "model"
+	}
+	if len(table.Columns) == 0 {
+		return nil, fmt.Errorf("database: table %s has no columns", table.Name)
+	}
+	name := utils.Under2Hump(strings.TrimPrefix(table.Name, opts.TrimPrefix))
+	comment := table.Comment
+	if comment == "" {
+		comment = table.Name
+	}
+
+	imports := map[string]bool{"go_lib/database": true, "go_lib/utils": true}
+	var fields, values []string
+	types := make(map[string]string)
+	for _, c := range table.Columns {
+		typ, pkg := GoType(c, opts.NullStyle)
+		if pkg != "" {
+			imports[pkg] = true
+		}
+		types[c.Name] = typ
+		field := fmt.Sprintf("\t%s %s `db:\"%s\" json:\"%s\"`", utils.Under2Hump(c.Name), typ, c.Name, c.Name)
+		if c.Comment != "" {
+			field += " // " + singleLine(c.Comment)
+		}
+		fields = append(fields, field)
+		if !c.AutoIncrement {
+			values = append(values, fmt.Sprintf("\t\t%q: r.%s,", c.Name, utils.Under2Hump(c.Name)))
+		}
+	}
+	keys := table.PrimaryKeys()
+
+	var buf strings.Builder
+	buf.WriteString("// Code generated by dbgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkgName)
+	buf.WriteString("import (\n")
+	for _, pkg := range sortedImports(imports) {
+		fmt.Fprintf(&buf, "\t%q\n", pkg)
+	}
+	buf.WriteString(")\n\n")
+
+	fmt.Fprintf(&buf, "// %s表名\nconst Table%s = %q\n\n", name, name, table.Name)
+	fmt.Fprintf(&buf, "// %s %s\ntype %s struct {\n%s\n}\n\n", name, singleLine(comment), name, strings.Join(fields, "\n"))
+	fmt.Fprintf(&buf, "// 表名\nfunc (%s) TableName() string {\n\treturn Table%s\n}\n\n", name, name)
+
+	var keyNames []string
+	for _, k := range keys {
+		keyNames = append(keyNames, fmt.Sprintf("%q", k.Name))
+	}
+	fmt.Fprintf(&buf, "// 主键字段\nfunc (%s) PrimaryKeys() []string {\n\treturn []string{%s}\n}\n\n", name, strings.Join(keyNames, ", "))
+	fmt.Fprintf(&buf, "// 新增及更新的字段值，不包含自增字段\nfunc (r *%s) Values() utils.M {\n\treturn utils.M{\n%s\n\t}\n}\n\n", name, strings.Join(values, "\n"))
+
+	// 表操作
+	fmt.Fprintf(&buf, "// %s表的操作\ntype %sTable struct {\n\t*database.DBTable\n\tdb *database.SqlDB\n}\n\n", table.Name, name)
+	pk := "id"
+	if len(keys) > 0 {
+		pk = keys[0].Name
+	}
+	fmt.Fprintf(&buf, "// 新建%s表的操作\nfunc New%sTable(db *database.SqlDB) *%sTable {\n\treturn &%sTable{DBTable: db.Table(Table%s).PrimaryKey(%q), db: db}\n}\n\n", table.Name, name, name, name, name, pk)
+	fmt.Fprintf(&buf, "// 按当前条件查询记录\nfunc (t *%sTable) List() ([]*%s, error) {\n\tvar list []*%s\n\terr := t.Find(&list)\n\treturn list, err\n}\n\n", name, name, name)
+
+	// 新增，自增字段回写
+	fmt.Fprintf(&buf, "// 新增记录，自增主键回写到row中\nfunc (t *%sTable) Create(row *%s) error {\n", name, name)
+	var auto *SchemaColumn
+	for _, c := range table.Columns {
+		if c.AutoIncrement {
+			auto = c
+		}
+	}
+	if auto != nil && !strings.HasPrefix(types[auto.Name], "*") && !strings.HasPrefix(types[auto.Name], "sql.") {
+		fmt.Fprintf(&buf, "\tid, ok := t.Insert(row.Values())\n\tif !ok {\n\t\treturn t.db.GetLastError()\n\t}\n\trow.%s = %s(id)\n\treturn nil\n}\n\n", utils.Under2Hump(auto.Name), types[auto.Name])
+	} else {
+		buf.WriteString("\tif _, ok := t.Insert(row.Values()); !ok {\n\t\treturn t.db.GetLastError()\n\t}\n\treturn nil\n}\n\n")
+	}
+
+	if len(keys) > 0 {
+		var params, where, rowWhere []string
+		for _, k := range keys {
+			arg := lcFirst(utils.Under2Hump(k.Name))
+			params = append(params, fmt.Sprintf("%s %s", arg, types[k.Name]))
+			where = append(where, fmt.Sprintf("%q: %s", k.Name, arg))
+			rowWhere = append(rowWhere, fmt.Sprintf("%q: row.%s", k.Name, utils.Under2Hump(k.Name)))
+		}
+		paramStr, whereStr := strings.Join(params, ", "), strings.Join(where, ", ")
+		fmt.Fprintf(&buf, "// 根据主键获取记录，不存在时返回sql.ErrNoRows\nfunc (t *%sTable) Get(%s) (*%s, error) {\n\trow := &%s{}\n\tif err := t.Where(utils.M{%s}, \"\").First(row); err != nil {\n\t\treturn nil, err\n\t}\n\treturn row, nil\n}\n\n", name, paramStr, name, name, whereStr)
+		fmt.Fprintf(&buf, "// 根据主键更新记录\nfunc (t *%sTable) Save(row *%s) error {\n\tif !t.Where(utils.M{%s}, \"\").Update(row.Values()) {\n\t\treturn t.db.GetLastError()\n\t}\n\treturn nil\n}\n\n", name, name, strings.Join(rowWhere, ", "))
+		fmt.Fprintf(&buf, "// 根据主键删除记录\nfunc (t *%sTable) Remove(%s) error {\n\tif !t.Where(utils.M{%s}, \"\").Delete() {\n\t\treturn t.db.GetLastError()\n\t}\n\treturn nil\n}\n\n", name, paramStr, whereStr)
+	}
+
+	return format.Source([]byte(buf.String()))
+}
+
+// 生成所有表的代码并写入dir目录，每个表一个文件，返回生成的文件
+func GenerateFiles(tables []*SchemaTable, dir string, opts *GenOptions) ([]string, error) {
+	if len(tables) == 0 {
+		return nil, errors.New("database: no tables to generate")
+	}
+	var files []string
+	for _, table := range tables {
+		code, err := GenerateCode(table, opts)
+		if err != nil {
+			return files, fmt.Errorf("database: generate %s: %v", table.Name, err)
+		}
+		file := filepath.Join(dir, strings.ToLower(table.Name)+".go")
+		if err = ioutil.WriteFile(file, code, 0644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// 按字母排序的引入包
+func sortedImports(imports map[string]bool) []string {
+	list := make([]string, 0, len(imports))
+	for pkg := range imports {
+		list = append(list, pkg)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// 注释中的换行替换为空格
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// 首字母小写
+func lcFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
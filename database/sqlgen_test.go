@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go/format"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试字段类型映射
+func TestGoType(t *testing.T) {
+	cases := []struct {
+		column    SchemaColumn
+		nullStyle string
+		expect    string
+	}{
+		{SchemaColumn{DataType: "tinyint", ColumnType: "tinyint(1)"}, "", "bool"},
+		{SchemaColumn{DataType: "tinyint", ColumnType: "tinyint(4)"}, "", "int8"},
+		{SchemaColumn{DataType: "int", ColumnType: "int(10) unsigned"}, "", "uint32"},
+		{SchemaColumn{DataType: "bigint", ColumnType: "bigint(20)"}, "", "int64"},
+		{SchemaColumn{DataType: "bigint", ColumnType: "bigint(20) unsigned"}, "", "uint64"},
+		{SchemaColumn{DataType: "decimal", ColumnType: "decimal(10,2)"}, "", "database.Decimal"},
+		{SchemaColumn{DataType: "datetime", ColumnType: "datetime"}, "", "time.Time"},
+		{SchemaColumn{DataType: "json", ColumnType: "json"}, "", "string"},
+		{SchemaColumn{DataType: "enum", ColumnType: "enum('a','b')"}, "", "string"},
+		{SchemaColumn{DataType: "blob", ColumnType: "blob"}, "", "[]byte"},
+		{SchemaColumn{DataType: "varchar", ColumnType: "varchar(32)", Nullable: true}, GenNullPointer, "*string"},
+		{SchemaColumn{DataType: "varchar", ColumnType: "varchar(32)", Nullable: true}, GenNullSql, "sql.NullString"},
+		{SchemaColumn{DataType: "int", ColumnType: "int(11)", Nullable: true}, GenNullSql, "*int32"},
+		{SchemaColumn{DataType: "bigint", ColumnType: "bigint(20)", Nullable: true}, GenNullSql, "sql.NullInt64"},
+		{SchemaColumn{DataType: "timestamp", ColumnType: "timestamp", Nullable: true}, GenNullSql, "*time.Time"},
+		{SchemaColumn{DataType: "blob", ColumnType: "blob", Nullable: true}, GenNullPointer, "[]byte"},
+		{SchemaColumn{DataType: "numeric", ColumnType: "numeric", Nullable: true}, GenNullPointer, "*database.Decimal"},
+	}
+	for _, c := range cases {
+		if typ, _ := GoType(&c.column, c.nullStyle); typ != c.expect {
+			t.Errorf("%s %s: expect %s, got %s", c.column.ColumnType, c.nullStyle, c.expect, typ)
+		}
+	}
+}
+
+// 测试读取表结构并生成代码
+func TestGenerateCode(t *testing.T) {
+	db, fake := newFakeSqlDB("gen", MysqlDialect{})
+	fake.setRows([]string{"table_name", "table_comment", "column_name", "data_type", "column_type", "is_nullable", "column_key", "extra", "column_comment"},
+		[]driver.Value{"t_user", "用户", "id", "bigint", "bigint(20) unsigned", "NO", "PRI", "auto_increment", "ID"},
+		[]driver.Value{"t_user", "用户", "name", "varchar", "varchar(32)", "NO", "", "", "姓名"},
+		[]driver.Value{"t_user", "用户", "balance", "decimal", "decimal(10,2)", "YES", "", "", ""},
+		[]driver.Value{"t_user", "用户", "created_at", "datetime", "datetime", "NO", "", "", ""},
+		[]driver.Value{"t_user", "用户", "deleted_at", "datetime", "datetime", "YES", "", "", ""},
+	)
+	tables, err := db.LoadSchema("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || len(tables[0].Columns) != 5 || len(tables[0].PrimaryKeys()) != 1 {
+		t.Fatalf("unexpected schema: %+v", tables)
+	}
+	if _, err = db.LoadSchema("test", "t_missing"); err == nil {
+		t.Error("expect error for missing table")
+	}
+
+	code, err := GenerateCode(tables[0], &GenOptions{Package: "model", TrimPrefix: "t_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, _ := format.Source(code)
+	if string(formatted) != string(code) {
+		t.Error("generated code is not gofmt'd")
+	}
+	src := string(code)
+	for _, expect := range []string{
+		"// Code generated by dbgen. DO NOT EDIT.",
+		"package model",
+		`const TableUser = "t_user"`,
+		"type User struct {",
+		"Id        uint64            `db:\"id\" json:\"id\"`",
+		"Balance   *database.Decimal `db:\"balance\" json:\"balance\"`",
+		"DeletedAt *time.Time        `db:\"deleted_at\" json:\"deleted_at\"`",
+		`return []string{"id"}`,
+		"func NewUserTable(db *database.SqlDB) *UserTable {",
+		"func (t *UserTable) Get(id uint64) (*User, error) {",
+		"row.Id = uint64(id)",
+		"func (t *UserTable) Save(row *User) error {",
+		"func (t *UserTable) Remove(id uint64) error {",
+	} {
+		if !strings.Contains(src, expect) {
+			t.Errorf("generated code missing %q:\n%s", expect, src)
+		}
+	}
+	if strings.Contains(src, `"id": r.Id`) {
+		t.Error("auto increment column should not be in Values")
+	}
+}
+
+// 测试将字符串格式的时间扫描到time.Time字段
+func TestSqlDB_ScanTime(t *testing.T) {
+	db, fake := newFakeSqlDB("scan_time", MysqlDialect{})
+	fake.setRows([]string{"id", "created_at", "deleted_at"},
+		[]driver.Value{int64(1), []byte("2020-01-02 03:04:05"), nil},
+	)
+	var row struct {
+		Id        int64
+		CreatedAt time.Time
+		DeletedAt *time.Time
+	}
+	if err := db.QueryInto(&row, "SELECT * FROM t_user"); err != nil {
+		t.Fatal(err)
+	}
+	if !row.CreatedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) || row.DeletedAt != nil {
+		t.Errorf("unexpected row: %+v", row)
+	}
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL执行事件
+type QueryEvent struct {
+	Sql      string
+	Args     []interface{}
+	Start    time.Time     // 开始时间
+	Duration time.Duration // 执行耗时
+	Rows     int64         // 影响或返回的行数，无法获取时为-1
+	Err      error
+}
+
+// SQL执行钩子
+type Hook interface {
+	// 执行前调用，返回的上下文用于执行SQL及调用AfterQuery
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	// 执行后调用，event中包含耗时、行数及错误
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// 注册钩子，按注册顺序调用BeforeQuery，逆序调用AfterQuery
+func (m *SqlDB) AddHook(hooks ...Hook) {
+	m.hooks = append(m.hooks[:len(m.hooks):len(m.hooks)], hooks...)
+}
+
+// 执行前调用钩子，没有钩子时返回nil
+func (m *SqlDB) beforeQuery(ctx context.Context, sqlStr string, args []interface{}) (context.Context, *QueryEvent) {
+	if len(m.hooks) == 0 {
+		return ctx, nil
+	}
+	event := &QueryEvent{Sql: sqlStr, Args: args, Start: time.Now(), Rows: -1}
+	for _, h := range m.hooks {
+		ctx = h.BeforeQuery(ctx, event)
+	}
+	return ctx, event
+}
+
+// 执行后调用钩子
+func (m *SqlDB) afterQuery(ctx context.Context, event *QueryEvent, rows int64, err error) {
+	if event == nil {
+		return
+	}
+	event.Duration = time.Since(event.Start)
+	event.Rows = rows
+	event.Err = err
+	for i := len(m.hooks) - 1; i >= 0; i-- {
+		m.hooks[i].AfterQuery(ctx, event)
+	}
+}
+
+// 默认需要脱敏的字段
+var defaultRedactColumns = []string{"password", "passwd", "pwd", "secret", "token"}
+
+// 内置的SQL日志，输出key=value格式
+type QueryLogger struct {
+	Logger        *log.Logger   // 输出目标，默认为标准错误
+	SlowThreshold time.Duration // 慢查询阈值，0表示不区分
+	SlowOnly      bool          // 只记录慢查询及错误
+	Interpolate   bool          // 调试模式，将参数代入SQL中输出
+	RedactColumns []string      // 需要脱敏的字段，包含其中任意一个词的字段的值输出为***，默认为password、secret、token等
+}
+
+// 新建SQL日志
+func NewQueryLogger() *QueryLogger {
+	return &QueryLogger{Logger: log.New(os.Stderr, "[sql] ", log.LstdFlags)}
+}
+
+func (l *QueryLogger) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (l *QueryLogger) AfterQuery(ctx context.Context, event *QueryEvent) {
+	level, msg := "INFO", "query"
+	switch {
+	case event.Err != nil:
+		level, msg = "ERROR", "query failed"
+	case l.SlowThreshold > 0 && event.Duration >= l.SlowThreshold:
+		level, msg = "WARN", "slow query"
+	case l.SlowOnly:
+		return
+	}
+
+	redact := l.RedactColumns
+	if redact == nil {
+		redact = defaultRedactColumns
+	}
+	args := redactArgs(event.Sql, event.Args, redact)
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "level=%s msg=%q duration=%s rows=%d", level, msg, event.Duration, event.Rows)
+	if l.Interpolate {
+		fmt.Fprintf(&buf, " sql=%q", InterpolateSql(event.Sql, args))
+	} else {
+		fmt.Fprintf(&buf, " sql=%q args=%q", event.Sql, "["+strings.Join(formatArgs(args), ", ")+"]")
+	}
+	if event.Err != nil {
+		fmt.Fprintf(&buf, " err=%q", event.Err.Error())
+	}
+	logger := l.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "[sql] ", log.LstdFlags)
+	}
+	logger.Println(buf.String())
+}
+
+// 已脱敏的参数
+type redacted struct{}
+
+// 按字段名将敏感参数替换为redacted
+func redactArgs(sqlStr string, args []interface{}, columns []string) []interface{} {
+	if len(args) == 0 || len(columns) == 0 {
+		return args
+	}
+	var result []interface{}
+	for i, c := range placeholderColumns(sqlStr) {
+		if i >= len(args) {
+			break
+		}
+		c = strings.ToLower(c)
+		for _, s := range columns {
+			if strings.Contains(c, s) {
+				if result == nil {
+					result = append([]interface{}{}, args...)
+				}
+				result[i] = redacted{}
+				break
+			}
+		}
+	}
+	if result == nil {
+		return args
+	}
+	return result
+}
+
+// 获取每个占位符对应的字段名
+// 条件及更新语句取占位符前最近的字段，INSERT的VALUES按字段列表的位置对应
+func placeholderColumns(sqlStr string) []string {
+	var (
+		columns    []string
+		ident      string   // 最近的字段名
+		prev       string   // 上一个单词
+		insertCols []string // INSERT的字段列表
+		inList     bool     // 正在读取INSERT字段列表
+		inValues   bool     // 正在读取VALUES
+		depth      int
+		index      int // VALUES中当前值的位置
+	)
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case c == '\'':
+			// 跳过字符串
+			for i++; i < len(sqlStr) && sqlStr[i] != '\''; i++ {
+			}
+		case c == '`' || c == '"' || isWordChar(c):
+			var word string
+			if c == '`' || c == '"' {
+				end := strings.IndexByte(sqlStr[i+1:], c)
+				if end == -1 {
+					end = len(sqlStr) - i - 1
+				}
+				word = sqlStr[i+1 : i+1+end]
+				i += end + 1
+			} else {
+				start := i
+				for i+1 < len(sqlStr) && isWordChar(sqlStr[i+1]) {
+					i++
+				}
+				word = sqlStr[start : i+1]
+			}
+			upper := strings.ToUpper(word)
+			switch {
+			case c == '$' || (c >= '0' && c <= '9'):
+				if c == '$' {
+					columns = append(columns, placeholderColumn(inValues, depth, insertCols, index, ident))
+				}
+			case upper == "VALUES" && insertCols != nil && depth == 0:
+				inValues = true
+			case c != '`' && c != '"' && isKeyword(upper):
+				if depth == 0 {
+					inValues = false
+				}
+			default:
+				ident = word
+				if inList {
+					insertCols = append(insertCols, word)
+				}
+			}
+			prev = upper
+		case c == '(':
+			if depth == 0 && insertCols == nil && prev != "" && strings.Contains(strings.ToUpper(sqlStr[:i]), "INSERT INTO") {
+				inList = true
+			}
+			if inValues && depth == 0 {
+				index = 0
+			}
+			depth++
+		case c == ')':
+			depth--
+			if inList && depth == 0 {
+				inList = false
+				if insertCols == nil {
+					insertCols = []string{}
+				}
+			}
+		case c == ',':
+			if inValues && depth == 1 {
+				index++
+			}
+		case c == '?':
+			columns = append(columns, placeholderColumn(inValues, depth, insertCols, index, ident))
+		}
+	}
+	return columns
+}
+
+// 获取占位符对应的字段
+func placeholderColumn(inValues bool, depth int, insertCols []string, index int, ident string) string {
+	if inValues && depth == 1 {
+		if index < len(insertCols) {
+			return insertCols[index]
+		}
+		return ""
+	}
+	return ident
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// 常用的SQL关键字，不作为字段名
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true, "IN": true, "IS": true,
+	"NULL": true, "LIKE": true, "BETWEEN": true, "SET": true, "UPDATE": true, "INSERT": true, "INTO": true,
+	"DELETE": true, "ON": true, "AS": true, "JOIN": true, "LEFT": true, "RIGHT": true, "INNER": true,
+	"GROUP": true, "BY": true, "ORDER": true, "HAVING": true, "LIMIT": true, "OFFSET": true, "DUPLICATE": true,
+	"KEY": true, "CONFLICT": true, "DO": true, "RETURNING": true, "EXISTS": true, "REGEXP": true, "ASC": true, "DESC": true,
+}
+
+func isKeyword(word string) bool {
+	return sqlKeywords[word]
+}
+
+// 格式化参数列表
+func formatArgs(args []interface{}) []string {
+	list := make([]string, 0, len(args))
+	for _, a := range args {
+		list = append(list, formatValue(a))
+	}
+	return list
+}
+
+// 将参数代入SQL中，支持?及$n占位符，仅用于日志输出
+func InterpolateSql(sqlStr string, args []interface{}) string {
+	var buf strings.Builder
+	var quote byte
+	index := 0
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && index < len(args):
+			buf.WriteString(formatValue(args[index]))
+			index++
+			continue
+		case c == '$' && i+1 < len(sqlStr) && sqlStr[i+1] >= '0' && sqlStr[i+1] <= '9':
+			j := i + 1
+			for j < len(sqlStr) && sqlStr[j] >= '0' && sqlStr[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(sqlStr[i+1 : j])
+			if n >= 1 && n <= len(args) {
+				buf.WriteString(formatValue(args[n-1]))
+				i = j - 1
+				continue
+			}
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// 将参数格式化为SQL字面量
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case redacted:
+		return "'***'"
+	case string:
+		return "'" + strings.Replace(val, "'", "''", -1) + "'"
+	case []byte:
+		return fmt.Sprintf("X'%x'", val)
+	case time.Time:
+		return "'" + val.Format("2006-01-02 15:04:05.999999") + "'"
+	case bool:
+		if val {
+			return "TRUE"
+		}
+		return "FALSE"
+	case fmt.Stringer:
+		return "'" + strings.Replace(val.String(), "'", "''", -1) + "'"
+	}
+	return fmt.Sprint(v)
+}
+
+// DSN中的密码
+var dsnPasswordReg = regexp.MustCompile(`(?i)(password=)[^\s&]*`)
+
+// 隐藏DSN中的密码
+func RedactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return strings.Replace(u.String(), "xxxxx", "***", 1)
+		}
+	}
+	// user:password@tcp(host)/db
+	if at := strings.LastIndex(dsn, "@"); at != -1 {
+		if colon := strings.Index(dsn[:at], ":"); colon != -1 && !strings.Contains(dsn[:at], "://") {
+			return dsn[:colon+1] + "***" + dsn[at:]
+		}
+	}
+	return dsnPasswordReg.ReplaceAllString(dsn, "${1}***")
+}
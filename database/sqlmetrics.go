@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 默认的耗时分布区间（秒）
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// 默认的统计实例，开启DBMetrics时使用
+var DefaultMetrics = NewMetrics(nil)
+
+// SQL执行统计，按表及操作类型记录次数、错误数、行数及耗时分布
+// 实现了Hook接口，通过AddHook注册到SqlDB
+type Metrics struct {
+	mu      sync.Mutex
+	buckets []float64
+	series  map[metricKey]*metricSeries
+}
+
+type metricKey struct {
+	table string
+	op    string
+}
+
+type metricSeries struct {
+	count   uint64
+	errors  uint64
+	rows    uint64
+	sum     float64  // 总耗时（秒）
+	buckets []uint64 // 每个区间的次数，不累加
+}
+
+// 单个表及操作的统计
+type QueryMetric struct {
+	Table   string            `json:"table"`
+	Op      string            `json:"op"`
+	Count   uint64            `json:"count"`
+	Errors  uint64            `json:"errors"`
+	Rows    uint64            `json:"rows"`
+	Seconds float64           `json:"seconds"` // 总耗时
+	Buckets map[string]uint64 `json:"buckets"` // 耗时小于等于区间上限的累计次数
+}
+
+// 统计快照
+type MetricsSnapshot struct {
+	Queries []QueryMetric          `json:"queries"`
+	Pools   map[string]sql.DBStats `json:"pools"`
+}
+
+// 新建统计，buckets为耗时分布区间（秒），为空时使用DefaultLatencyBuckets
+func NewMetrics(buckets []float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{buckets: buckets, series: make(map[metricKey]*metricSeries)}
+}
+
+func (s *Metrics) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (s *Metrics) AfterQuery(ctx context.Context, event *QueryEvent) {
+	op, table := parseStatement(event.Sql)
+	seconds := event.Duration.Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := metricKey{table: table, op: op}
+	series, ok := s.series[key]
+	if !ok {
+		series = &metricSeries{buckets: make([]uint64, len(s.buckets))}
+		s.series[key] = series
+	}
+	series.count++
+	series.sum += seconds
+	if event.Err != nil {
+		series.errors++
+	}
+	if event.Rows > 0 {
+		series.rows += uint64(event.Rows)
+	}
+	for i, b := range s.buckets {
+		if seconds <= b {
+			series.buckets[i]++
+			break
+		}
+	}
+}
+
+// 清空统计
+func (s *Metrics) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series = make(map[metricKey]*metricSeries)
+}
+
+// 获取统计快照，同时包含SqlDrivers中所有连接池的状态
+func (s *Metrics) Snapshot() *MetricsSnapshot {
+	snapshot := &MetricsSnapshot{Pools: poolStats()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.sortedKeys() {
+		series := s.series[key]
+		metric := QueryMetric{
+			Table:   key.table,
+			Op:      key.op,
+			Count:   series.count,
+			Errors:  series.errors,
+			Rows:    series.rows,
+			Seconds: series.sum,
+			Buckets: make(map[string]uint64, len(s.buckets)+1),
+		}
+		var total uint64
+		for i, b := range s.buckets {
+			total += series.buckets[i]
+			metric.Buckets[formatFloat(b)] = total
+		}
+		metric.Buckets["+Inf"] = series.count
+		snapshot.Queries = append(snapshot.Queries, metric)
+	}
+	return snapshot
+}
+
+// 按表名及操作排序的键
+func (s *Metrics) sortedKeys() []metricKey {
+	keys := make([]metricKey, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].table != keys[j].table {
+			return keys[i].table < keys[j].table
+		}
+		return keys[i].op < keys[j].op
+	})
+	return keys
+}
+
+// 以Prometheus文本格式输出统计
+func (s *Metrics) WritePrometheus(w io.Writer) error {
+	var buf strings.Builder
+	s.mu.Lock()
+	keys := s.sortedKeys()
+	counters := []struct {
+		name  string
+		help  string
+		value func(*metricSeries) uint64
+	}{
+		{"sqldb_queries_total", "Total number of executed statements.", func(m *metricSeries) uint64 { return m.count }},
+		{"sqldb_errors_total", "Total number of failed statements.", func(m *metricSeries) uint64 { return m.errors }},
+		{"sqldb_rows_total", "Total number of affected or returned rows.", func(m *metricSeries) uint64 { return m.rows }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "%s{%s} %d\n", c.name, key.labels(), c.value(s.series[key]))
+		}
+	}
+	name := "sqldb_query_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Statement latency in seconds.\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		series := s.series[key]
+		labels := key.labels()
+		var total uint64
+		for i, b := range s.buckets {
+			total += series.buckets[i]
+			fmt.Fprintf(&buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(b), total)
+		}
+		fmt.Fprintf(&buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, series.count)
+		fmt.Fprintf(&buf, "%s_sum{%s} %s\n", name, labels, formatFloat(series.sum))
+		fmt.Fprintf(&buf, "%s_count{%s} %d\n", name, labels, series.count)
+	}
+	s.mu.Unlock()
+
+	writePoolStats(&buf, poolStats())
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// 发布到expvar，可通过/debug/vars查看，重复发布同一名称时忽略
+func (s *Metrics) PublishExpvar(name string) {
+	if expvar.Get(name) != nil {
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Snapshot()
+	}))
+}
+
+func (k metricKey) labels() string {
+	return fmt.Sprintf(`table="%s",op="%s"`, escapeLabel(k.table), escapeLabel(k.op))
+}
+
+// 获取所有连接池的状态，key中的密码已隐藏
+func poolStats() map[string]sql.DBStats {
+	sqlDriversLock.RLock()
+	dbs := make(map[string]*sql.DB, len(SqlDrivers))
+	for key, db := range SqlDrivers {
+		dbs[key] = db
+	}
+	sqlDriversLock.RUnlock()
+	stats := make(map[string]sql.DBStats, len(dbs))
+	for key, db := range dbs {
+		stats[redactDriverKey(key)] = db.Stats()
+	}
+	return stats
+}
+
+// 输出连接池状态
+func writePoolStats(buf *strings.Builder, pools map[string]sql.DBStats) {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := []struct {
+		name  string
+		help  string
+		kind  string
+		value func(sql.DBStats) string
+	}{
+		{"sqldb_pool_max_open_connections", "Maximum number of open connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.MaxOpenConnections) }},
+		{"sqldb_pool_open_connections", "Number of established connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.OpenConnections) }},
+		{"sqldb_pool_in_use_connections", "Number of connections currently in use.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.InUse) }},
+		{"sqldb_pool_idle_connections", "Number of idle connections.", "gauge", func(s sql.DBStats) string { return strconv.Itoa(s.Idle) }},
+		{"sqldb_pool_wait_count_total", "Total number of connections waited for.", "counter", func(s sql.DBStats) string { return strconv.FormatInt(s.WaitCount, 10) }},
+		{"sqldb_pool_wait_duration_seconds_total", "Total time blocked waiting for a connection.", "counter", func(s sql.DBStats) string { return formatFloat(s.WaitDuration.Seconds()) }},
+		{"sqldb_pool_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", "counter", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxIdleClosed, 10) }},
+		{"sqldb_pool_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", "counter", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxLifetimeClosed, 10) }},
+	}
+	for _, g := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s{db=\"%s\"} %s\n", g.name, escapeLabel(name), g.value(pools[name]))
+		}
+	}
+}
+
+// 隐藏SqlDrivers的key中的密码，key的格式为 驱动名:DSN
+func redactDriverKey(key string) string {
+	if i := strings.Index(key, ":"); i != -1 {
+		return key[:i+1] + RedactDsn(key[i+1:])
+	}
+	return key
+}
+
+// 从SQL中解析操作类型及表名
+func parseStatement(sqlStr string) (op string, table string) {
+	words := strings.Fields(sqlStr)
+	if len(words) == 0 {
+		return "other", ""
+	}
+	op = strings.ToLower(words[0])
+	var keyword string
+	switch op {
+	case "select", "delete":
+		keyword = "FROM"
+	case "insert", "replace":
+		keyword = "INTO"
+	case "update":
+		return op, tableName(words, 1)
+	default:
+		return op, ""
+	}
+	for i, w := range words {
+		if strings.ToUpper(w) == keyword {
+			// 跳过FROM后的子查询
+			if name := tableName(words, i+1); name != "" {
+				return op, name
+			}
+		}
+	}
+	return op, ""
+}
+
+// 获取第i个单词中的表名，去掉引号及括号后的内容
+func tableName(words []string, i int) string {
+	if i >= len(words) || strings.HasPrefix(words[i], "(") {
+		return ""
+	}
+	name := words[i]
+	if j := strings.Index(name, "("); j != -1 {
+		name = name[:j]
+	}
+	return strings.Trim(name, "`\"")
+}
+
+// 转义标签值
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
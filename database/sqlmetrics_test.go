@@ -0,0 +1,129 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"go_lib/utils"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 测试SQL执行统计
+func TestMetrics(t *testing.T) {
+	db, fake := newFakeSqlDB("metrics", MysqlDialect{})
+	fake.changed = 2
+	metrics := NewMetrics([]float64{1, 0.5})
+	db.AddHook(metrics)
+	sqlDriversLock.Lock()
+	SqlDrivers["fakedb:root:secret@tcp(127.0.0.1:3306)/test"] = db.db
+	sqlDriversLock.Unlock()
+	defer func() {
+		sqlDriversLock.Lock()
+		delete(SqlDrivers, "fakedb:root:secret@tcp(127.0.0.1:3306)/test")
+		sqlDriversLock.Unlock()
+	}()
+
+	_ = db.Update(utils.M{"age": 1}, utils.M{"id": 1}, "t_user")
+	_ = db.Update(utils.M{"age": 1}, utils.M{"id": 2}, "t_user")
+	_, _ = db.Exec("DELETE FROM `t_FAIL` WHERE id = 1")
+	_, _ = db.Table("t_order").Where(utils.M{"id": 1}, "").Query().Result()
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expect := range []string{
+		`sqldb_queries_total{table="t_user",op="update"} 2`,
+		`sqldb_rows_total{table="t_user",op="update"} 4`,
+		`sqldb_errors_total{table="t_FAIL",op="delete"} 1`,
+		`sqldb_queries_total{table="t_order",op="select"} 1`,
+		`sqldb_query_duration_seconds_bucket{table="t_user",op="update",le="0.5"} 2`,
+		`sqldb_query_duration_seconds_bucket{table="t_user",op="update",le="+Inf"} 2`,
+		`sqldb_query_duration_seconds_count{table="t_user",op="update"} 2`,
+		`sqldb_pool_open_connections{db="fakedb:root:***@tcp(127.0.0.1:3306)/test"}`,
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("missing %s in:\n%s", expect, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Error("password should be redacted")
+	}
+
+	metrics.PublishExpvar("sqldb_test")
+	metrics.PublishExpvar("sqldb_test")
+	var snapshot MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get("sqldb_test").String()), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Queries) != 3 || snapshot.Queries[2].Table != "t_user" || snapshot.Queries[2].Buckets["1"] != 2 {
+		t.Errorf("unexpected snapshot: %+v", snapshot.Queries)
+	}
+}
+
+// 测试解析操作类型及表名
+func TestParseStatement(t *testing.T) {
+	tests := map[string][2]string{
+		"INSERT INTO `t_user`(`name`) VALUES(?)":                          {"insert", "t_user"},
+		`UPDATE "t_user" SET "age" = $1`:                                  {"update", "t_user"},
+		"SELECT count(*) FROM (SELECT 1 FROM `t_order`  WHERE 1) t_count": {"select", "t_order"},
+		"BEGIN": {"begin", ""},
+	}
+	for sqlStr, expect := range tests {
+		if op, table := parseStatement(sqlStr); op != expect[0] || table != expect[1] {
+			t.Errorf("%s: %s %s", sqlStr, op, table)
+		}
+	}
+}
+
+// 测试打开连接池时并发获取连接池状态
+func TestPoolStatsConcurrent(t *testing.T) {
+	var keys []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			conf := &DBConfig{DBDriver: "mysql", DBHost: "127.0.0.1", DBPort: "3306", DBName: "pool_" + strconv.Itoa(i)}
+			if _, err := openSqlDb(conf); err != nil {
+				t.Error(err)
+				return
+			}
+			keys = append(keys, "mysql:"+MysqlDialect{}.Dsn(conf))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			poolStats()
+		}
+	}
+	sqlDriversLock.Lock()
+	defer sqlDriversLock.Unlock()
+	for _, key := range keys {
+		_ = SqlDrivers[key].Close()
+		delete(SqlDrivers, key)
+	}
+}
+
+// 测试查询单行失败时记录错误数
+func TestMetrics_QueryRowError(t *testing.T) {
+	db, _ := newFakeSqlDB("metrics_query_row", MysqlDialect{})
+	metrics := NewMetrics(nil)
+	db.AddHook(metrics)
+
+	db.Table("t_user").Where(utils.M{"name": "FAIL"}, "").Rows()
+	db.Table("t_user").Where(utils.M{"name": "FAIL"}, "").Rows()
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if expect := `sqldb_errors_total{table="t_user",op="select"} 2`; !strings.Contains(buf.String(), expect) {
+		t.Errorf("missing %s in:\n%s", expect, buf.String())
+	}
+}
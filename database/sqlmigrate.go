@@ -0,0 +1,561 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go_lib/utils"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 自动迁移的结果
+type MigrateResult struct {
+	Executed []string // 已执行的建表、新增字段及索引语句
+	Pending  []string // 字段类型变更需要执行的语句，不会自动执行
+}
+
+// 返回表名的模型
+type tableNamer interface {
+	TableName() string
+}
+
+// 模型字段
+type modelColumn struct {
+	name          string
+	typ           string // 数据库类型
+	nullable      bool
+	primaryKey    bool
+	autoIncrement bool
+	def           string // 默认值，为空表示没有默认值
+	hasDefault    bool
+	comment       string
+	integer       bool // 是否为整数类型
+}
+
+// 模型索引
+type modelIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// 模型对应的表结构
+type modelTable struct {
+	name    string
+	columns []*modelColumn
+	indexes []*modelIndex
+}
+
+var (
+	decimalType     = reflect.TypeOf(Decimal(""))
+	bytesType       = reflect.TypeOf([]byte(nil))
+	nullStringType  = reflect.TypeOf(sql.NullString{})
+	nullInt64Type   = reflect.TypeOf(sql.NullInt64{})
+	nullFloat64Type = reflect.TypeOf(sql.NullFloat64{})
+	nullBoolType    = reflect.TypeOf(sql.NullBool{})
+)
+
+// 根据结构体创建不存在的表，并新增缺少的字段及索引
+// 表名优先使用TableName方法的返回值，否则为下划线格式的结构体名
+// 字段名规则与查询结果扫描相同，sql标签设置字段属性，多个属性用分号分隔：
+//
+//	type:varchar(64)  字段类型，设置后忽略size
+//	size:64           字符串长度，默认255
+//	pk                主键，名为id的整数字段默认为自增主键
+//	auto_increment    自增
+//	null、not null    是否可空，指针及sql.Null*类型默认可空
+//	default:0         默认值
+//	index、index:name 索引，同名索引为组合索引
+//	unique、unique:name 唯一索引
+//	comment:说明      字段注释
+//
+// 已存在字段的类型与结构体不同时不会修改，变更语句在结果的Pending中返回
+func (m *SqlDB) AutoMigrate(models ...interface{}) (*MigrateResult, error) {
+	return m.AutoMigrateContext(context.Background(), models...)
+}
+
+// 自动迁移，支持上下文
+func (m *SqlDB) AutoMigrateContext(ctx context.Context, models ...interface{}) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	schema, err := m.currentSchema(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, model := range models {
+		table, err := parseModel(m.Dialect(), model)
+		if err != nil {
+			return result, err
+		}
+		exist, err := m.loadSchema(ctx, schema, table.name)
+		if err != nil {
+			return result, err
+		}
+		var executed, pending []string
+		if len(exist) == 0 {
+			executed = createTableSql(m.Dialect(), table)
+		} else {
+			indexes, err := m.indexNames(ctx, schema, table.name)
+			if err != nil {
+				return result, err
+			}
+			executed, pending = alterTableSql(m.Dialect(), table, exist[0], indexes)
+		}
+		for _, stmt := range executed {
+			if _, err = m.ExecContext(ctx, stmt); err != nil {
+				return result, err
+			}
+			result.Executed = append(result.Executed, stmt)
+		}
+		result.Pending = append(result.Pending, pending...)
+	}
+	return result, nil
+}
+
+// 获取当前的数据库或schema
+func (m *SqlDB) currentSchema(ctx context.Context) (string, error) {
+	var sqlStr string
+	switch m.Dialect().Name() {
+	case "mysql":
+		sqlStr = "SELECT DATABASE()"
+	case "postgres":
+		sqlStr = "SELECT current_schema()"
+	default:
+		return "", nil
+	}
+	var schema sql.NullString
+	if err := m.scanRow(ctx, sqlStr, nil, &schema); err != nil {
+		return "", err
+	}
+	return schema.String, nil
+}
+
+// 获取表已有的索引名
+func (m *SqlDB) indexNames(ctx context.Context, schema string, table string) (map[string]bool, error) {
+	var (
+		rows []utils.M
+		err  error
+	)
+	switch m.Dialect().Name() {
+	case "mysql":
+		rows, err = m.QueryContext(ctx, "SELECT DISTINCT INDEX_NAME AS name FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", schema, table)
+	case "postgres":
+		rows, err = m.QueryContext(ctx, "SELECT indexname AS name FROM pg_indexes WHERE schemaname = ? AND tablename = ?", schema, table)
+	default:
+		rows, err = m.QueryContext(ctx, fmt.Sprintf("PRAGMA index_list(%s)", m.FormatColumn(table)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		names[schemaString(row["name"])] = true
+	}
+	return names, nil
+}
+
+// 解析结构体的表结构
+func parseModel(d Dialect, model interface{}) (*modelTable, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("database: AutoMigrate needs a struct, got %T", model)
+	}
+	table := &modelTable{name: utils.Hump2Under(t.Name())}
+	if namer, ok := model.(tableNamer); ok {
+		table.name = namer.TableName()
+	} else if namer, ok := reflect.New(t).Interface().(tableNamer); ok {
+		table.name = namer.TableName()
+	}
+
+	// 按字段定义的顺序排列
+	fields := structFields(t)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := fields[names[i]], fields[names[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	indexes := make(map[string]*modelIndex)
+	hasPk := false
+	for _, name := range names {
+		f := t.FieldByIndex(fields[name])
+		col, err := parseModelColumn(d, table.name, name, f, indexes)
+		if err != nil {
+			return nil, err
+		}
+		hasPk = hasPk || col.primaryKey
+		table.columns = append(table.columns, col)
+	}
+	if len(table.columns) == 0 {
+		return nil, fmt.Errorf("database: %s has no columns", t.Name())
+	}
+	// 没有设置主键时，名为id的整数字段为自增主键
+	if !hasPk {
+		for _, col := range table.columns {
+			if col.name == "id" && col.integer {
+				col.primaryKey, col.autoIncrement, col.nullable = true, true, false
+			}
+		}
+	}
+	for _, idx := range indexes {
+		table.indexes = append(table.indexes, idx)
+	}
+	sort.Slice(table.indexes, func(i, j int) bool {
+		return table.indexes[i].name < table.indexes[j].name
+	})
+	return table, nil
+}
+
+// 解析字段及sql标签
+func parseModelColumn(d Dialect, table string, name string, f reflect.StructField, indexes map[string]*modelIndex) (*modelColumn, error) {
+	ft := f.Type
+	col := &modelColumn{name: name}
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+		col.nullable = true
+	}
+	switch ft {
+	case nullStringType, nullInt64Type, nullFloat64Type, nullBoolType:
+		col.nullable = true
+	}
+
+	var typ string
+	size := 255
+	for _, opt := range strings.Split(f.Tag.Get("sql"), ";") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		key, value := opt, ""
+		if i := strings.IndexByte(opt, ':'); i != -1 {
+			key, value = strings.TrimSpace(opt[:i]), strings.TrimSpace(opt[i+1:])
+		}
+		switch strings.ToLower(key) {
+		case "type":
+			typ = value
+		case "size":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("database: invalid size %q on %s.%s", value, table, name)
+			}
+			size = n
+		case "pk", "primary_key":
+			col.primaryKey = true
+		case "auto_increment":
+			col.autoIncrement = true
+		case "null":
+			col.nullable = true
+		case "not null":
+			col.nullable = false
+		case "default":
+			col.def, col.hasDefault = value, true
+		case "comment":
+			col.comment = value
+		case "auto_create_time", "auto_update_time", "version":
+			// 由Insert、Update及UpdateVersioned维护
+		case "index", "unique":
+			unique := strings.ToLower(key) == "unique"
+			if value == "" {
+				prefix := "idx_"
+				if unique {
+					prefix = "uk_"
+				}
+				value = prefix + table + "_" + name
+			}
+			idx, ok := indexes[value]
+			if !ok {
+				idx = &modelIndex{name: value, unique: unique}
+				indexes[value] = idx
+			}
+			idx.columns = append(idx.columns, name)
+		default:
+			return nil, fmt.Errorf("database: unknown sql tag %q on %s.%s", key, table, name)
+		}
+	}
+
+	col.integer = isIntKind(ft.Kind())
+	if col.primaryKey {
+		col.nullable = false
+	}
+	if typ == "" {
+		typ = columnTypeOf(d, ft, size)
+		if typ == "" {
+			return nil, fmt.Errorf("database: unsupported type %s of %s.%s, set it with sql:\"type:...\"", f.Type, table, name)
+		}
+	}
+	col.typ = typ
+	return col, nil
+}
+
+// 是否为整数类型
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uint64
+}
+
+// Go类型对应的数据库类型，不支持的类型返回空
+func columnTypeOf(d Dialect, t reflect.Type, size int) string {
+	name := d.Name()
+	pick := func(mysql, postgres, sqlite string) string {
+		switch name {
+		case "mysql":
+			return mysql
+		case "postgres":
+			return postgres
+		}
+		return sqlite
+	}
+	switch t {
+	case timeType:
+		return pick("DATETIME", "TIMESTAMP", "DATETIME")
+	case decimalType:
+		return pick("DECIMAL(20,6)", "NUMERIC(20,6)", "NUMERIC")
+	case bytesType:
+		return pick("BLOB", "BYTEA", "BLOB")
+	case nullStringType:
+		t = reflect.TypeOf("")
+	case nullInt64Type:
+		t = reflect.TypeOf(int64(0))
+	case nullFloat64Type:
+		t = reflect.TypeOf(float64(0))
+	case nullBoolType:
+		t = reflect.TypeOf(false)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return pick("TINYINT(1)", "BOOLEAN", "INTEGER")
+	case reflect.Int8:
+		return pick("TINYINT", "SMALLINT", "INTEGER")
+	case reflect.Uint8:
+		return pick("TINYINT UNSIGNED", "SMALLINT", "INTEGER")
+	case reflect.Int16:
+		return pick("SMALLINT", "SMALLINT", "INTEGER")
+	case reflect.Uint16:
+		return pick("SMALLINT UNSIGNED", "INTEGER", "INTEGER")
+	case reflect.Int32:
+		return pick("INT", "INTEGER", "INTEGER")
+	case reflect.Uint32:
+		return pick("INT UNSIGNED", "BIGINT", "INTEGER")
+	case reflect.Int, reflect.Int64:
+		return pick("BIGINT", "BIGINT", "INTEGER")
+	case reflect.Uint, reflect.Uint64:
+		return pick("BIGINT UNSIGNED", "NUMERIC(20)", "INTEGER")
+	case reflect.Float32:
+		return pick("FLOAT", "REAL", "REAL")
+	case reflect.Float64:
+		return pick("DOUBLE", "DOUBLE PRECISION", "REAL")
+	case reflect.String:
+		return pick(fmt.Sprintf("VARCHAR(%d)", size), fmt.Sprintf("VARCHAR(%d)", size), "TEXT")
+	}
+	return ""
+}
+
+// 字段定义
+func columnDefinition(d Dialect, col *modelColumn, inlinePk bool) string {
+	typ := col.typ
+	if col.autoIncrement && inlinePk {
+		switch d.Name() {
+		case "postgres":
+			if strings.HasPrefix(strings.ToUpper(typ), "BIGINT") {
+				typ = "BIGSERIAL"
+			} else {
+				typ = "SERIAL"
+			}
+		case "sqlite3":
+			typ = "INTEGER"
+		}
+	}
+	parts := []string{d.Quote(col.name), typ}
+	if !col.nullable {
+		parts = append(parts, "NOT NULL")
+	}
+	if col.hasDefault {
+		parts = append(parts, "DEFAULT "+col.def)
+	}
+	if inlinePk {
+		parts = append(parts, "PRIMARY KEY")
+		if col.autoIncrement {
+			switch d.Name() {
+			case "mysql":
+				parts = append(parts, "AUTO_INCREMENT")
+			case "sqlite3":
+				parts = append(parts, "AUTOINCREMENT")
+			}
+		}
+	}
+	if col.comment != "" && d.Name() == "mysql" {
+		parts = append(parts, "COMMENT "+quoteString(col.comment))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 字符串转为SQL字面量
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// 建表及索引语句
+func createTableSql(d Dialect, table *modelTable) []string {
+	var pks []string
+	for _, col := range table.columns {
+		if col.primaryKey {
+			pks = append(pks, col.name)
+		}
+	}
+	defs := make([]string, 0, len(table.columns)+1)
+	for _, col := range table.columns {
+		defs = append(defs, columnDefinition(d, col, col.primaryKey && len(pks) == 1))
+	}
+	if len(pks) > 1 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoteColumns(d, pks), ", ")))
+	}
+	list := []string{fmt.Sprintf("CREATE TABLE %s (%s)", d.Quote(table.name), strings.Join(defs, ", "))}
+	list = append(list, commentSql(d, table.name, table.columns)...)
+	for _, idx := range table.indexes {
+		list = append(list, createIndexSql(d, table.name, idx))
+	}
+	return list
+}
+
+// postgres的字段注释需要单独设置
+func commentSql(d Dialect, table string, columns []*modelColumn) []string {
+	if d.Name() != "postgres" {
+		return nil
+	}
+	var list []string
+	for _, col := range columns {
+		if col.comment != "" {
+			list = append(list, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", d.Quote(table), d.Quote(col.name), quoteString(col.comment)))
+		}
+	}
+	return list
+}
+
+// 建索引语句
+func createIndexSql(d Dialect, table string, idx *modelIndex) string {
+	unique := ""
+	if idx.unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, d.Quote(idx.name), d.Quote(table), strings.Join(quoteColumns(d, idx.columns), ", "))
+}
+
+// 对比已有的表结构，返回新增字段、索引的语句及类型变更的语句
+func alterTableSql(d Dialect, table *modelTable, exist *SchemaTable, indexes map[string]bool) ([]string, []string) {
+	columns := make(map[string]*SchemaColumn, len(exist.Columns))
+	for _, c := range exist.Columns {
+		columns[strings.ToLower(c.Name)] = c
+	}
+	var executed, pending []string
+	var added []*modelColumn
+	for _, col := range table.columns {
+		c, ok := columns[strings.ToLower(col.name)]
+		if !ok {
+			// 已有的表不能再新增主键
+			add := *col
+			add.primaryKey, add.autoIncrement = false, false
+			// postgres及sqlite不能给已有数据的表新增没有默认值的非空字段，先新增为可空字段，非空约束在填充数据后执行
+			if !add.nullable && !add.hasDefault && d.Name() != "mysql" {
+				add.nullable = true
+				if d.Name() == "postgres" {
+					pending = append(pending, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", d.Quote(table.name), d.Quote(col.name)))
+				} else {
+					pending = append(pending, fmt.Sprintf("-- %s.%s: NOT NULL, sqlite needs to rebuild the table", table.name, col.name))
+				}
+			}
+			added = append(added, &add)
+			executed = append(executed, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", d.Quote(table.name), columnDefinition(d, &add, false)))
+			continue
+		}
+		if sameColumnType(c, col.typ) {
+			continue
+		}
+		switch d.Name() {
+		case "mysql":
+			pending = append(pending, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", d.Quote(table.name), columnDefinition(d, col, false)))
+		case "postgres":
+			pending = append(pending, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", d.Quote(table.name), d.Quote(col.name), col.typ))
+		default:
+			// sqlite不支持修改字段类型，需要重建表
+			pending = append(pending, fmt.Sprintf("-- %s.%s: %s => %s, sqlite needs to rebuild the table", table.name, col.name, columnTypeName(c), col.typ))
+		}
+	}
+	executed = append(executed, commentSql(d, table.name, added)...)
+	for _, idx := range table.indexes {
+		if !indexes[idx.name] {
+			executed = append(executed, createIndexSql(d, table.name, idx))
+		}
+	}
+	return executed, pending
+}
+
+// 已有字段的类型名
+func columnTypeName(c *SchemaColumn) string {
+	if c.ColumnType != "" {
+		return c.ColumnType
+	}
+	return c.DataType
+}
+
+// 数据库中的类型别名
+var typeAliases = map[string]string{
+	"integer":                     "int",
+	"int4":                        "int",
+	"int8":                        "bigint",
+	"int2":                        "smallint",
+	"serial":                      "int",
+	"bigserial":                   "bigint",
+	"bool":                        "boolean",
+	"float8":                      "double precision",
+	"float4":                      "real",
+	"character varying":           "varchar",
+	"character":                   "char",
+	"bpchar":                      "char",
+	"numeric":                     "decimal",
+	"timestamp without time zone": "timestamp",
+	"timestamptz":                 "timestamp with time zone",
+}
+
+// 已有字段的类型是否与结构体一致，数据库没有返回长度、精度时只比较类型
+func sameColumnType(c *SchemaColumn, want string) bool {
+	have := normalizeType(columnTypeName(c))
+	if !strings.Contains(have, "(") {
+		if i := strings.IndexByte(want, '('); i != -1 {
+			want = want[:i] + want[strings.IndexByte(want, ')')+1:]
+		}
+	}
+	return have == normalizeType(want)
+}
+
+// 统一类型的写法，去掉整数的显示宽度并替换别名
+func normalizeType(typ string) string {
+	typ = strings.Join(strings.Fields(strings.ToLower(typ)), " ")
+	base, rest := typ, ""
+	if i := strings.IndexByte(typ, '('); i != -1 {
+		base, rest = typ[:i], typ[i:]
+	} else if i := strings.IndexByte(typ, ' '); i != -1 && !strings.Contains(typ, "precision") && !strings.Contains(typ, "varying") && !strings.Contains(typ, "time zone") {
+		base, rest = typ[:i], typ[i:]
+	}
+	if alias, ok := typeAliases[base]; ok {
+		base = alias
+	}
+	// 整数的显示宽度不影响类型，tinyint(1)表示布尔值除外
+	if strings.HasSuffix(base, "int") && strings.HasPrefix(rest, "(") && typ != "tinyint(1)" && !strings.HasPrefix(typ, "tinyint(1) ") {
+		if end := strings.IndexByte(rest, ')'); end != -1 {
+			rest = rest[end+1:]
+		}
+	}
+	return base + rest
+}
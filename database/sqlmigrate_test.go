@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type migrateUser struct {
+	Id        int64
+	Name      string    `sql:"size:64;index;comment:姓名"`
+	Email     string    `db:"email" sql:"unique"`
+	Balance   Decimal   `sql:"default:0"`
+	Status    int8      `sql:"index:idx_status_created"`
+	CreatedAt time.Time `sql:"index:idx_status_created"`
+	DeletedAt *time.Time
+	Ignore    string `db:"-"`
+}
+
+func (migrateUser) TableName() string {
+	return "t_user"
+}
+
+// 测试根据结构体生成建表语句
+func TestAutoMigrate_CreateTable(t *testing.T) {
+	table, err := parseModel(MysqlDialect{}, &migrateUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"CREATE TABLE `t_user` (`id` BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT, `name` VARCHAR(64) NOT NULL COMMENT '姓名', " +
+			"`email` VARCHAR(255) NOT NULL, `balance` DECIMAL(20,6) NOT NULL DEFAULT 0, `status` TINYINT NOT NULL, " +
+			"`created_at` DATETIME NOT NULL, `deleted_at` DATETIME)",
+		"CREATE INDEX `idx_status_created` ON `t_user` (`status`, `created_at`)",
+		"CREATE INDEX `idx_t_user_name` ON `t_user` (`name`)",
+		"CREATE UNIQUE INDEX `uk_t_user_email` ON `t_user` (`email`)",
+	}
+	if list := createTableSql(MysqlDialect{}, table); !reflect.DeepEqual(list, expect) {
+		t.Errorf("unexpected sql:\n%s", strings.Join(list, "\n"))
+	}
+
+	table, _ = parseModel(PostgresDialect{}, migrateUser{})
+	list := createTableSql(PostgresDialect{}, table)
+	if !strings.HasPrefix(list[0], `CREATE TABLE "t_user" ("id" BIGSERIAL NOT NULL PRIMARY KEY, "name" VARCHAR(64) NOT NULL,`) ||
+		list[1] != `COMMENT ON COLUMN "t_user"."name" IS '姓名'` {
+		t.Errorf("unexpected sql:\n%s", strings.Join(list, "\n"))
+	}
+
+	if _, err = parseModel(MysqlDialect{}, struct {
+		Tags map[string]string
+	}{}); err == nil {
+		t.Error("expect error for unsupported type")
+	}
+
+	// 表不存在时建表
+	db, fake := newFakeSqlDB("auto_migrate_create", SqliteDialect{})
+	fake.setRows([]string{"table_name"})
+	result, err := db.AutoMigrate(&migrateUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Executed) != 4 || !strings.HasPrefix(result.Executed[0], `CREATE TABLE "t_user" ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT`) {
+		t.Errorf("unexpected result: %v", result.Executed)
+	}
+	queries := fake.log()
+	if queries[len(queries)-1] != result.Executed[3] {
+		t.Errorf("statements not executed: %v", queries)
+	}
+}
+
+// 测试新增字段、索引及类型变更
+func TestAutoMigrate_AlterTable(t *testing.T) {
+	table, _ := parseModel(MysqlDialect{}, &migrateUser{})
+	exist := &SchemaTable{Name: "t_user", Columns: []*SchemaColumn{
+		{Name: "id", DataType: "bigint", ColumnType: "bigint(20)", PrimaryKey: true, AutoIncrement: true},
+		{Name: "name", DataType: "varchar", ColumnType: "varchar(32)"},
+		{Name: "email", DataType: "varchar", ColumnType: "varchar(255)"},
+		{Name: "status", DataType: "tinyint", ColumnType: "tinyint(4)"},
+		{Name: "created_at", DataType: "datetime", ColumnType: "datetime"},
+	}}
+	executed, pending := alterTableSql(MysqlDialect{}, table, exist, map[string]bool{"PRIMARY": true, "uk_t_user_email": true})
+	expect := []string{
+		"ALTER TABLE `t_user` ADD COLUMN `balance` DECIMAL(20,6) NOT NULL DEFAULT 0",
+		"ALTER TABLE `t_user` ADD COLUMN `deleted_at` DATETIME",
+		"CREATE INDEX `idx_status_created` ON `t_user` (`status`, `created_at`)",
+		"CREATE INDEX `idx_t_user_name` ON `t_user` (`name`)",
+	}
+	if !reflect.DeepEqual(executed, expect) {
+		t.Errorf("unexpected executed:\n%s", strings.Join(executed, "\n"))
+	}
+	if !reflect.DeepEqual(pending, []string{"ALTER TABLE `t_user` MODIFY COLUMN `name` VARCHAR(64) NOT NULL COMMENT '姓名'"}) {
+		t.Errorf("unexpected pending: %v", pending)
+	}
+
+	// postgres没有返回精度时只比较类型
+	if !sameColumnType(&SchemaColumn{DataType: "numeric", ColumnType: "numeric"}, "NUMERIC(20,6)") ||
+		!sameColumnType(&SchemaColumn{DataType: "bigint", ColumnType: "int8"}, "BIGINT") ||
+		!sameColumnType(&SchemaColumn{DataType: "tinyint", ColumnType: "tinyint(3) unsigned"}, "TINYINT UNSIGNED") ||
+		sameColumnType(&SchemaColumn{DataType: "tinyint", ColumnType: "tinyint(1)"}, "TINYINT") {
+		t.Error("unexpected type comparison")
+	}
+
+	// 已有表时只新增缺少的字段，类型变更不执行
+	db, fake := newFakeSqlDB("auto_migrate_alter", SqliteDialect{})
+	fake.setRows([]string{"table_name", "name", "type", "notnull", "pk"},
+		[]driver.Value{"t_user", "id", "INTEGER", int64(1), int64(1)},
+		[]driver.Value{"t_user", "name", "INTEGER", int64(1), int64(0)},
+	)
+	result, err := db.AutoMigrate(&migrateUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range result.Executed {
+		if strings.Contains(stmt, `ADD COLUMN "id"`) || strings.Contains(stmt, `ADD COLUMN "name"`) {
+			t.Errorf("existing column added: %s", stmt)
+		}
+	}
+	// email、status、created_at新增为可空字段
+	if len(result.Pending) != 4 || strings.Contains(strings.Join(fake.log(), "\n"), "name: integer") {
+		t.Errorf("unexpected pending: %v", result.Pending)
+	}
+	if stmt := result.Executed[0]; stmt != `ALTER TABLE "t_user" ADD COLUMN "email" TEXT` {
+		t.Errorf("unexpected executed: %s", stmt)
+	}
+}
+
+// 测试postgres新增非空字段时先新增为可空字段
+func TestAutoMigrate_AddNotNull(t *testing.T) {
+	table, _ := parseModel(PostgresDialect{}, &migrateUser{})
+	exist := &SchemaTable{Name: "t_user", Columns: []*SchemaColumn{
+		{Name: "id", DataType: "bigint", PrimaryKey: true, AutoIncrement: true},
+		{Name: "name", DataType: "character varying", ColumnType: "varchar(64)"},
+		{Name: "email", DataType: "character varying", ColumnType: "varchar(255)"},
+		{Name: "created_at", DataType: "timestamp without time zone", ColumnType: "timestamp"},
+		{Name: "deleted_at", DataType: "timestamp without time zone", ColumnType: "timestamp"},
+	}}
+	executed, pending := alterTableSql(PostgresDialect{}, table, exist, map[string]bool{"uk_t_user_email": true, "idx_status_created": true, "idx_t_user_name": true})
+	expect := []string{
+		`ALTER TABLE "t_user" ADD COLUMN "balance" NUMERIC(20,6) NOT NULL DEFAULT 0`,
+		`ALTER TABLE "t_user" ADD COLUMN "status" SMALLINT`,
+	}
+	if !reflect.DeepEqual(executed, expect) {
+		t.Errorf("unexpected executed:\n%s", strings.Join(executed, "\n"))
+	}
+	if !reflect.DeepEqual(pending, []string{`ALTER TABLE "t_user" ALTER COLUMN "status" SET NOT NULL`}) {
+		t.Errorf("unexpected pending: %v", pending)
+	}
+}
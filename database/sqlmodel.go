@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// 模型的生命周期钩子，模型实现对应的接口后由SqlDB及DBTable调用
+// db为执行语句的SqlDB，在事务中时为事务的SqlDB，钩子中的查询与语句在同一个事务中执行
+// Before钩子返回错误时不执行语句，After钩子的错误作为操作的错误返回，事务中可据此回滚
+// 钩子声明在指针接收者上时需要传入结构体指针，按值传入时返回错误，避免钩子被静默跳过
+
+// 新增前调用，Insert、InsertMany及Upsert传入的结构体
+type BeforeInsertHook interface {
+	BeforeInsert(ctx context.Context, db *SqlDB) error
+}
+
+// 新增成功后调用
+type AfterInsertHook interface {
+	AfterInsert(ctx context.Context, db *SqlDB) error
+}
+
+// 更新前调用，UpdateVersioned传入的结构体或通过DBTable.Model设置的模型
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context, db *SqlDB) error
+}
+
+// 更新成功后调用
+type AfterUpdateHook interface {
+	AfterUpdate(ctx context.Context, db *SqlDB) error
+}
+
+// 删除前调用，通过DBTable.Model设置的模型
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context, db *SqlDB) error
+}
+
+// 查询结果扫描到结构体后调用，Find、First、QueryInto及Cursor.Scan
+type AfterFindHook interface {
+	AfterFind(ctx context.Context, db *SqlDB) error
+}
+
+// 设置本次更新、删除操作的模型，用于调用模型的钩子
+func (t *DBTable) Model(model interface{}) *DBTable {
+	t.model = model
+	return t
+}
+
+// 对单个模型或模型切片中的每个模型执行fn，切片元素取地址以便调用指针接收者的方法
+func eachModel(data interface{}, fn func(model interface{}) error) error {
+	if data == nil {
+		return nil
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		if err := checkModel(data); err != nil {
+			return err
+		}
+		return fn(data)
+	}
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Struct && elem.CanAddr() {
+			elem = elem.Addr()
+		}
+		if err := checkModel(elem.Interface()); err != nil {
+			return err
+		}
+		if err := fn(elem.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 钩子接口
+var hookTypes = []reflect.Type{
+	reflect.TypeOf((*BeforeInsertHook)(nil)).Elem(),
+	reflect.TypeOf((*AfterInsertHook)(nil)).Elem(),
+	reflect.TypeOf((*BeforeUpdateHook)(nil)).Elem(),
+	reflect.TypeOf((*AfterUpdateHook)(nil)).Elem(),
+	reflect.TypeOf((*BeforeDeleteHook)(nil)).Elem(),
+	reflect.TypeOf((*AfterFindHook)(nil)).Elem(),
+}
+
+// 结构体按值传入时无法调用指针接收者的钩子，返回错误提示传入指针
+func checkModel(model interface{}) error {
+	t := reflect.TypeOf(model)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, hook := range hookTypes {
+		if !t.Implements(hook) && reflect.PtrTo(t).Implements(hook) {
+			return fmt.Errorf("database: hooks of %s have pointer receivers, pass *%s", t, t.Name())
+		}
+	}
+	return nil
+}
+
+// 新增前调用钩子
+func (m *SqlDB) beforeInsert(ctx context.Context, data interface{}) error {
+	return eachModel(data, func(model interface{}) error {
+		if h, ok := model.(BeforeInsertHook); ok {
+			return h.BeforeInsert(ctx, m)
+		}
+		return nil
+	})
+}
+
+// 新增后调用钩子
+func (m *SqlDB) afterInsert(ctx context.Context, data interface{}) error {
+	return eachModel(data, func(model interface{}) error {
+		if h, ok := model.(AfterInsertHook); ok {
+			return h.AfterInsert(ctx, m)
+		}
+		return nil
+	})
+}
+
+// 更新前调用钩子
+func (m *SqlDB) beforeUpdate(ctx context.Context, model interface{}) error {
+	if err := checkModel(model); err != nil {
+		return err
+	}
+	if h, ok := model.(BeforeUpdateHook); ok {
+		return h.BeforeUpdate(ctx, m)
+	}
+	return nil
+}
+
+// 更新后调用钩子
+func (m *SqlDB) afterUpdate(ctx context.Context, model interface{}) error {
+	if err := checkModel(model); err != nil {
+		return err
+	}
+	if h, ok := model.(AfterUpdateHook); ok {
+		return h.AfterUpdate(ctx, m)
+	}
+	return nil
+}
+
+// 删除前调用钩子
+func (m *SqlDB) beforeDelete(ctx context.Context, model interface{}) error {
+	if err := checkModel(model); err != nil {
+		return err
+	}
+	if h, ok := model.(BeforeDeleteHook); ok {
+		return h.BeforeDelete(ctx, m)
+	}
+	return nil
+}
+
+// 查询后调用钩子，dest为结构体指针或结构体切片的指针
+func (m *SqlDB) afterFind(ctx context.Context, dest interface{}) error {
+	return eachModel(dest, func(model interface{}) error {
+		if h, ok := model.(AfterFindHook); ok {
+			return h.AfterFind(ctx, m)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+)
+
+type hookedUser struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	hooks hookState
+}
+
+// 钩子的调用记录，未导出的字段不作为表字段
+type hookState struct {
+	calls []string
+	db    *SqlDB
+	fail  string
+}
+
+func (u *hookedUser) state() *hookState {
+	return &u.hooks
+}
+
+func (u *hookedUser) hook(name string, db *SqlDB) error {
+	s := u.state()
+	s.calls = append(s.calls, name)
+	s.db = db
+	if s.fail == name {
+		return errors.New(name + " failed")
+	}
+	return nil
+}
+
+func (u *hookedUser) BeforeInsert(ctx context.Context, db *SqlDB) error {
+	return u.hook("BeforeInsert", db)
+}
+
+func (u *hookedUser) AfterInsert(ctx context.Context, db *SqlDB) error {
+	return u.hook("AfterInsert", db)
+}
+
+func (u *hookedUser) BeforeUpdate(ctx context.Context, db *SqlDB) error {
+	return u.hook("BeforeUpdate", db)
+}
+
+func (u *hookedUser) AfterUpdate(ctx context.Context, db *SqlDB) error {
+	return u.hook("AfterUpdate", db)
+}
+
+func (u *hookedUser) BeforeDelete(ctx context.Context, db *SqlDB) error {
+	return u.hook("BeforeDelete", db)
+}
+
+func (u *hookedUser) AfterFind(ctx context.Context, db *SqlDB) error {
+	return u.hook("AfterFind", db)
+}
+
+// 测试新增、更新、删除时调用模型的钩子
+func TestSqlDB_ModelHooks(t *testing.T) {
+	db, fake := newFakeSqlDB("model_hooks", MysqlDialect{})
+
+	u := &hookedUser{Name: "a"}
+	if _, ok := db.Table("t_user").Insert(u); !ok {
+		t.Fatal(db.GetLastError())
+	}
+	u.Id = 1
+	if !db.Table("t_user").Model(u).Where(utils.M{"id": u.Id}, "").Update(utils.M{"name": "b"}) {
+		t.Fatal(db.GetLastError())
+	}
+	if !db.Table("t_user").Model(u).Where(utils.M{"id": u.Id}, "").Delete() {
+		t.Fatal(db.GetLastError())
+	}
+	expect := []string{"BeforeInsert", "AfterInsert", "BeforeUpdate", "AfterUpdate", "BeforeDelete"}
+	if !reflect.DeepEqual(u.state().calls, expect) {
+		t.Errorf("unexpected calls: %v", u.state().calls)
+	}
+	if u.state().db != db {
+		t.Error("hook should receive the executing db")
+	}
+	if n := len(fake.log()); n != 3 {
+		t.Errorf("expect 3 statements, got %d", n)
+	}
+
+	// 模型只作用于本次操作
+	u.state().calls = nil
+	db.Table("t_user").Model(u).Where(utils.M{"id": 1}, "").Update(utils.M{"name": "c"})
+	db.Table("t_user").Where(utils.M{"id": 1}, "").Update(utils.M{"name": "d"})
+	if len(u.state().calls) != 2 {
+		t.Errorf("unexpected calls: %v", u.state().calls)
+	}
+}
+
+// 测试Before钩子返回错误时不执行语句
+func TestSqlDB_ModelHookAbort(t *testing.T) {
+	db, fake := newFakeSqlDB("model_hook_abort", MysqlDialect{})
+
+	u := &hookedUser{Name: "a"}
+	u.state().fail = "BeforeInsert"
+	if _, err := db.InsertContext(context.Background(), "t_user", u); err == nil || err != db.GetLastError() {
+		t.Errorf("expect before insert error, got %v", err)
+	}
+	if _, err := db.InsertMany("t_user", []*hookedUser{{Name: "b"}, u}); err == nil {
+		t.Error("expect before insert error")
+	}
+	u.state().fail = "BeforeUpdate"
+	if db.Table("t_user").Model(u).Where(utils.M{"id": 1}, "").Update(utils.M{"name": "b"}) {
+		t.Error("expect update aborted")
+	}
+	if err := db.Table("t_user").Where(utils.M{"id": 1}, "").UpdateVersioned(u, 1); err == nil {
+		t.Error("expect versioned update aborted")
+	}
+	u.state().fail = "BeforeDelete"
+	if db.Table("t_user").Model(u).Where(utils.M{"id": 1}, "").Delete() {
+		t.Error("expect delete aborted")
+	}
+	if log := fake.log(); len(log) != 0 {
+		t.Errorf("unexpected statements: %v", log)
+	}
+
+	// After钩子的错误作为操作的错误返回
+	u.state().fail = "AfterInsert"
+	if _, ok := db.Table("t_user").Insert(u); ok || db.GetLastError() == nil {
+		t.Error("expect after insert error")
+	}
+}
+
+// 测试钩子在事务中执行
+func TestSqlDB_ModelHookTransaction(t *testing.T) {
+	db, _ := newFakeSqlDB("model_hook_tx", MysqlDialect{})
+
+	u := &hookedUser{Name: "a"}
+	u.state().fail = "AfterInsert"
+	var txDB *SqlDB
+	err := db.Transaction(func(tx *Tx) error {
+		txDB = tx.SqlDB
+		if _, ok := tx.Table("t_user").Insert(u); !ok {
+			return tx.GetLastError()
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "AfterInsert failed" {
+		t.Errorf("expect after insert error, got %v", err)
+	}
+	if u.state().db == nil || u.state().db != txDB {
+		t.Error("hook should receive the transaction db")
+	}
+}
+
+// 测试查询后调用AfterFind
+func TestSqlDB_AfterFindHook(t *testing.T) {
+	db, fake := newFakeSqlDB("model_after_find", MysqlDialect{})
+
+	fake.setRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+	var list []hookedUser
+	if err := db.Table("t_user").Find(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || len(list[0].state().calls) != 1 || len(list[1].state().calls) != 1 {
+		t.Errorf("expect AfterFind on each row: %+v", list)
+	}
+
+	fake.setRows([]string{"id", "name"}, []driver.Value{int64(1), "a"})
+	cur, err := db.Table("t_user").Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	for cur.Next() {
+		u := &hookedUser{}
+		u.state().fail = "AfterFind"
+		if err = cur.Scan(u); err == nil || u.Id != 1 {
+			t.Errorf("expect AfterFind error, got %v", err)
+		}
+	}
+}
+
+// 测试插入更新时调用新增的钩子
+func TestSqlDB_UpsertHooks(t *testing.T) {
+	db, fake := newFakeSqlDB("model_upsert_hooks", MysqlDialect{})
+
+	u := &hookedUser{Id: 1, Name: "a"}
+	if _, err := db.Upsert("t_user", u, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Table("t_user").Upsert(u, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"BeforeInsert", "AfterInsert", "BeforeInsert", "AfterInsert"}
+	if !reflect.DeepEqual(u.state().calls, expect) {
+		t.Errorf("unexpected calls: %v", u.state().calls)
+	}
+
+	u.state().fail = "BeforeInsert"
+	if _, err := db.Table("t_user").Upsert(u, []string{"name"}); err == nil || db.GetLastError() != err {
+		t.Errorf("expect before insert error, got %v", err)
+	}
+	if n := len(fake.log()); n != 2 {
+		t.Errorf("expect 2 statements, got %d", n)
+	}
+}
+
+// 测试按值传入指针接收者钩子的结构体时返回错误
+func TestSqlDB_ModelHookByValue(t *testing.T) {
+	db, fake := newFakeSqlDB("model_hook_by_value", MysqlDialect{})
+
+	if _, ok := db.Table("t_user").Insert(hookedUser{Name: "a"}); ok || db.GetLastError() == nil {
+		t.Error("struct value with pointer hooks should fail")
+	}
+	if db.Table("t_user").Model(hookedUser{}).Where(utils.M{"id": 1}, "").Delete() {
+		t.Error("model value with pointer hooks should fail")
+	}
+	if log := fake.log(); len(log) != 0 {
+		t.Errorf("unexpected statements: %v", log)
+	}
+	// 切片中的元素可以取地址，按值传入切片时仍然调用钩子
+	list := []hookedUser{{Name: "b"}}
+	if _, err := db.InsertMany("t_user", list); err != nil {
+		t.Fatal(err)
+	}
+	if calls := list[0].state().calls; len(calls) != 2 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+// 测试事务中Before钩子返回错误时回滚
+func TestSqlDB_ModelHookRollback(t *testing.T) {
+	db, fake := newFakeSqlDB("model_hook_rollback", MysqlDialect{})
+
+	first := &hookedUser{Name: "a"}
+	second := &hookedUser{Name: "b"}
+	second.state().fail = "BeforeInsert"
+	err := db.Transaction(func(tx *Tx) error {
+		if _, ok := tx.Table("t_user").Insert(first); !ok {
+			return tx.GetLastError()
+		}
+		if _, ok := tx.Table("t_user").Insert(second); !ok {
+			return tx.GetLastError()
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "BeforeInsert failed" {
+		t.Errorf("expect before insert error, got %v", err)
+	}
+	expect := []string{"BEGIN", "INSERT INTO `t_user`(`id`,`name`) VALUES(?,?)", "ROLLBACK"}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected statements: %q", log)
+	}
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go_lib/utils"
+)
+
+// 无效的分页标记
+var ErrInvalidPageToken = errors.New("database: invalid page token")
+
+// 分页查询结果
+type PageResult struct {
+	List    interface{} `json:"list"`     // 当前页的记录，Paginate为[]utils.M，PaginateFind为传入的dest
+	Total   int         `json:"total"`    // 总记录数
+	Page    int         `json:"page"`     // 当前页码，从1开始
+	Size    int         `json:"size"`     // 每页数量
+	Pages   int         `json:"pages"`    // 总页数
+	HasNext bool        `json:"has_next"` // 是否有下一页
+}
+
+// 按主键分页的查询结果
+type SeekResult struct {
+	List    []utils.M `json:"list"`
+	Next    string    `json:"next"`     // 下一页的分页标记，没有下一页时为空
+	HasNext bool      `json:"has_next"` // 是否有下一页
+}
+
+// 分页标记的内容
+type pageToken struct {
+	Table string      `json:"t"`
+	After interface{} `json:"a"`
+}
+
+// 分页查询，返回当前页的记录及总数、总页数，查询后清除查询条件
+func (t *DBTable) Paginate(page int, size int) (*PageResult, error) {
+	var list []utils.M
+	res, err := t.paginate(page, size, func() error {
+		var err error
+		list, err = t.Result()
+		return err
+	})
+	if res != nil {
+		res.List = list
+	}
+	return res, err
+}
+
+// 分页查询并将当前页的记录扫描到dest中，dest为结构体切片的指针
+func (t *DBTable) PaginateFind(dest interface{}, page int, size int) (*PageResult, error) {
+	res, err := t.paginate(page, size, func() error {
+		return t.Find(dest)
+	})
+	if res != nil {
+		res.List = dest
+	}
+	return res, err
+}
+
+// 查询总数后执行fetch获取当前页的记录，超出总页数时不再查询
+func (t *DBTable) paginate(page int, size int, fetch func() error) (*PageResult, error) {
+	defer t.Clear()
+	if size <= 0 {
+		t.err = errors.New("database: page size must be positive")
+	}
+	if page < 1 {
+		page = 1
+	}
+	total, err := t.total()
+	if err != nil {
+		return nil, err
+	}
+	res := &PageResult{
+		Total: total,
+		Page:  page,
+		Size:  size,
+		Pages: (total + size - 1) / size,
+	}
+	res.HasNext = page < res.Pages
+	if (page-1)*size >= total {
+		return res, nil
+	}
+	t.Limit(size, page).Query()
+	return res, fetch()
+}
+
+// 按主键分页，查询主键大于lastId的记录，lastId为nil时从第一条开始
+// 主键通过PrimaryKey设置，默认为id，用于OFFSET较大时查询较慢的表
+func (t *DBTable) After(lastId interface{}) *DBTable {
+	t.after = lastId
+	return t
+}
+
+// 按Seek返回的分页标记继续查询，标记为空时从第一条开始
+func (t *DBTable) AfterToken(token string) *DBTable {
+	if token == "" {
+		t.after = nil
+		return t
+	}
+	after, err := decodePageToken(t.table, token)
+	if err != nil {
+		t.err = err
+		return t
+	}
+	t.after = after
+	return t
+}
+
+// 按主键升序查询size条记录，返回下一页的分页标记，查询后清除查询条件
+func (t *DBTable) Seek(size int) (*SeekResult, error) {
+	defer t.Clear()
+	if size <= 0 {
+		t.err = errors.New("database: page size must be positive")
+	}
+	if t.after != nil {
+		t.Where(utils.M{t.pk + "[>]": t.after}, "")
+	}
+	// 多查一条用于判断是否有下一页
+	t.OrderBy(t.pk).Limit(size+1, 1).Query()
+	list, err := t.Result()
+	if err != nil {
+		return nil, err
+	}
+	res := &SeekResult{List: list}
+	if len(list) > size {
+		res.List = list[:size]
+		res.HasNext = true
+		last, ok := list[size-1][t.pk]
+		if !ok {
+			err = errors.New("database: primary key " + t.pk + " is not selected")
+			t.db.setLastError(err)
+			return nil, err
+		}
+		res.Next = encodePageToken(t.table, last)
+	}
+	return res, nil
+}
+
+// 生成分页标记
+func encodePageToken(table string, after interface{}) string {
+	switch v := after.(type) {
+	case []byte:
+		after = string(v)
+	case Decimal:
+		after = string(v)
+	}
+	data, _ := json.Marshal(pageToken{Table: table, After: after})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// 解析分页标记，标记需要属于同一个表
+func decodePageToken(table string, token string) (interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var pt pageToken
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&pt); err != nil || pt.Table != table || pt.After == nil {
+		return nil, ErrInvalidPageToken
+	}
+	// 数字优先转为整数，避免大整数丢失精度
+	if n, ok := pt.After.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		if f, err := n.Float64(); err == nil {
+			return f, nil
+		}
+		return nil, ErrInvalidPageToken
+	}
+	return pt.After, nil
+}
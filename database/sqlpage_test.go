@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 测试分页查询
+func TestDBTable_Paginate(t *testing.T) {
+	db, fake := newFakeSqlDB("paginate", MysqlDialect{})
+	fake.setRows([]string{"count(*)"}, []driver.Value{int64(25)})
+
+	table := db.Table("t_user")
+	res, err := table.Where(utils.M{"status": 1}, "").OrderBy("id DESC").Paginate(3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 25 || res.Pages != 3 || res.Page != 3 || res.HasNext {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	expect := []string{
+		"SELECT count(*) FROM `t_user`  WHERE (`t_user`.`status` = ?)",
+		"SELECT * FROM `t_user`  WHERE (`t_user`.`status` = ?) ORDER BY `t_user`.`id` DESC LIMIT 20,10",
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+
+	// 超出总页数时不查询记录
+	res, err = table.Paginate(4, 10)
+	if err != nil || res.HasNext || res.List.([]utils.M) != nil || len(fake.log()) != 3 {
+		t.Errorf("unexpected result: %+v %v", res, err)
+	}
+
+	// 查询后清除查询条件
+	table.Where(utils.M{"status": 1}, "").Rows()
+	_, _ = table.Query().Result()
+	if log := fake.log(); strings.TrimSpace(log[len(log)-1]) != "SELECT * FROM `t_user`" {
+		t.Errorf("builder state leaked: %s", log[len(log)-1])
+	}
+
+	if _, err = table.Paginate(1, 0); err == nil {
+		t.Error("expect error for zero page size")
+	}
+}
+
+// 测试按主键分页
+func TestDBTable_Seek(t *testing.T) {
+	db, fake := newFakeSqlDB("seek", MysqlDialect{})
+	fake.setRows([]string{"id", "name"},
+		[]driver.Value{int64(11), "a"},
+		[]driver.Value{int64(12), "b"},
+		[]driver.Value{int64(13), "c"},
+	)
+
+	res, err := db.Table("t_user").Where(utils.M{"status": 1}, "").After(10).Seek(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.List) != 2 || !res.HasNext || res.Next == "" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	expect := "SELECT * FROM `t_user`  WHERE (`t_user`.`status` = ?) AND (`t_user`.`id` > ?) ORDER BY `t_user`.`id` ASC LIMIT 3"
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+
+	// 使用分页标记继续查询
+	_, err = db.Table("t_user").AfterToken(res.Next).Seek(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fake.args[1], []driver.Value{int64(12)}) {
+		t.Errorf("unexpected args: %v", fake.args[1])
+	}
+
+	// 其他表的标记及无效的标记
+	if _, err = db.Table("t_order").AfterToken(res.Next).Seek(2); err != ErrInvalidPageToken {
+		t.Errorf("expect invalid token, got %v", err)
+	}
+	if _, err = db.Table("t_user").AfterToken("!!").Seek(2); err != ErrInvalidPageToken {
+		t.Errorf("expect invalid token, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 从库选择策略
+const (
+	ReplicaRoundRobin = "round_robin" // 轮询
+	ReplicaWeighted   = "weighted"    // 按权重
+)
+
+// 从库连接失败后暂停使用的时间
+var ReplicaRetryInterval = 10 * time.Second
+
+// 从库配置，未设置的项使用主库的配置
+type DBReplica struct {
+	DBHost     string `json:"db_host" yaml:"db_host"`
+	DBPort     string `json:"db_port" yaml:"db_port"`
+	DBName     string `json:"db_name" yaml:"db_name"`
+	DBUser     string `json:"db_user" yaml:"db_user"`
+	DBPassword string `json:"db_password" yaml:"db_password"`
+	Weight     int    `json:"weight" yaml:"weight"` // 权重，默认为1
+}
+
+// 从库
+type replica struct {
+	db        *sql.DB
+	weight    int
+	downUntil int64 // 暂停使用的截止时间（纳秒）
+}
+
+// 标记为不可用
+func (r *replica) markDown() {
+	atomic.StoreInt64(&r.downUntil, time.Now().Add(ReplicaRetryInterval).UnixNano())
+}
+
+// 从库集合
+type replicaSet struct {
+	policy  string
+	list    []*replica
+	counter uint64
+}
+
+// 选择一个可用的从库，全部不可用时返回nil
+func (s *replicaSet) pick() *replica {
+	now := time.Now().UnixNano()
+	healthy := make([]*replica, 0, len(s.list))
+	total := 0
+	for _, r := range s.list {
+		if atomic.LoadInt64(&r.downUntil) <= now {
+			healthy = append(healthy, r)
+			total += r.weight
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&s.counter, 1) - 1
+	if s.policy != ReplicaWeighted {
+		return healthy[n%uint64(len(healthy))]
+	}
+	x := int(n % uint64(total))
+	for _, r := range healthy {
+		if x < r.weight {
+			return r
+		}
+		x -= r.weight
+	}
+	return healthy[0]
+}
+
+// 连接从库，连接失败的从库暂停使用
+func newReplicaSet(conf *DBConfig) (*replicaSet, error) {
+	set := &replicaSet{policy: conf.DBReplicaPolicy}
+	for _, rc := range conf.DBReplicas {
+		c := *conf
+		c.DBReplicas = nil
+		if rc.DBHost != "" {
+			c.DBHost = rc.DBHost
+		}
+		if rc.DBPort != "" {
+			c.DBPort = rc.DBPort
+		}
+		if rc.DBName != "" {
+			c.DBName = rc.DBName
+		}
+		if rc.DBUser != "" {
+			c.DBUser = rc.DBUser
+		}
+		if rc.DBPassword != "" {
+			c.DBPassword = rc.DBPassword
+		}
+		db, err := openSqlDb(&c)
+		if err != nil {
+			return nil, err
+		}
+		r := &replica{db: db, weight: rc.Weight}
+		if r.weight <= 0 {
+			r.weight = 1
+		}
+		if err = db.Ping(); err != nil {
+			r.markDown()
+		}
+		set.list = append(set.list, r)
+	}
+	return set, nil
+}
+
+// 关闭所有从库
+func (s *replicaSet) close() error {
+	var err error
+	for _, r := range s.list {
+		if e := r.db.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type primaryKey struct{}
+
+// 返回强制使用主库查询的上下文，用于写入后立即读取
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// 是否强制使用主库
+func isUsePrimary(ctx context.Context) bool {
+	use, _ := ctx.Value(primaryKey{}).(bool)
+	return use
+}
+
+// 为只读语句选择从库，事务中、强制主库或非只读语句返回nil
+func (m *SqlDB) pickReplica(ctx context.Context, query string) *replica {
+	if m.replicas == nil || m.tx != nil || isUsePrimary(ctx) || !isReadQuery(query) {
+		return nil
+	}
+	return m.replicas.pick()
+}
+
+// 是否为只读语句，加锁的查询也需要在主库执行
+func isReadQuery(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(query, "SELECT") {
+		return false
+	}
+	return !strings.Contains(query, " FOR UPDATE") && !strings.Contains(query, " FOR SHARE") && !strings.Contains(query, " LOCK IN SHARE MODE")
+}
+
+// 是否为连接错误
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"go_lib/utils"
+	"testing"
+)
+
+// 为测试数据库添加从库
+func addFakeReplica(db *SqlDB, name string, weight int) *fakeDB {
+	replicaDB, fake := newFakeSqlDB(name, db.Dialect())
+	if db.replicas == nil {
+		db.replicas = &replicaSet{}
+	}
+	db.replicas.list = append(db.replicas.list, &replica{db: replicaDB.db, weight: weight})
+	return fake
+}
+
+// 测试读写分离
+func TestSqlDB_Replica(t *testing.T) {
+	db, primary := newFakeSqlDB("replica_primary", MysqlDialect{})
+	r1 := addFakeReplica(db, "replica_1", 1)
+	r2 := addFakeReplica(db, "replica_2", 1)
+	for _, f := range []*fakeDB{primary, r1, r2} {
+		f.setRows([]string{"id"}, []driver.Value{int64(1)})
+	}
+
+	for i := 0; i < 4; i++ {
+		_, _ = db.Table("t_user").Where(utils.M{"id": 1}, "").Query().Result()
+	}
+	_ = db.Table("t_user").Rows()
+	_ = db.Update(utils.M{"age": 1}, utils.M{"id": 1}, "t_user")
+	_, _ = db.Query("SELECT * FROM t_user WHERE id = 1 FOR UPDATE")
+	_, _ = db.Table("t_user").UsePrimary().Query().Result()
+	_ = db.Transaction(func(tx *Tx) error {
+		_, err := tx.Query("SELECT 1")
+		return err
+	})
+	if len(r1.log()) != 3 || len(r2.log()) != 2 {
+		t.Errorf("unexpected replica queries: %q %q", r1.log(), r2.log())
+	}
+	if len(primary.log()) != 6 {
+		t.Errorf("unexpected primary queries: %q", primary.log())
+	}
+
+	// 从库断开时改为查询主库，并暂停使用该从库
+	r1.down = true
+	r2.down = true
+	list, err := db.QueryContext(context.Background(), "SELECT * FROM t_user")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("fallback failed: %v %v", list, err)
+	}
+	if db.replicas.pick() == nil {
+		// 两个从库中只有被选中的那个被标记
+		t.Error("only the failed replica should be marked down")
+	}
+	_, _ = db.Query("SELECT * FROM t_user")
+	if r := db.replicas.pick(); r != nil {
+		t.Error("all replicas should be marked down")
+	}
+	if len(primary.log()) != 8 {
+		t.Errorf("unexpected primary queries: %q", primary.log())
+	}
+}
+
+// 测试查询记录数时从库断开，改为查询主库
+func TestSqlDB_ReplicaRows(t *testing.T) {
+	db, primary := newFakeSqlDB("replica_rows_primary", MysqlDialect{})
+	replica := addFakeReplica(db, "replica_rows_1", 1)
+	primary.setRows([]string{"count(*)"}, []driver.Value{int64(3)})
+	replica.down = true
+
+	if rows := db.Table("t_user").Rows(); rows != 3 || db.GetLastError() != nil {
+		t.Errorf("fallback failed: %d %v", rows, db.GetLastError())
+	}
+	if db.replicas.pick() != nil {
+		t.Error("failed replica should be marked down")
+	}
+	// QueryRow只使用主库
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM t_user").Scan(&count); err != nil || count != 3 {
+		t.Errorf("query row failed: %d %v", count, err)
+	}
+	if len(primary.log()) != 2 {
+		t.Errorf("unexpected primary queries: %q", primary.log())
+	}
+}
+
+// 测试按权重选择从库
+func TestReplicaSet_Weighted(t *testing.T) {
+	a, b := &replica{weight: 3}, &replica{weight: 1}
+	set := &replicaSet{policy: ReplicaWeighted, list: []*replica{a, b}}
+	count := map[*replica]int{}
+	for i := 0; i < 8; i++ {
+		count[set.pick()]++
+	}
+	if count[a] != 6 || count[b] != 2 {
+		t.Errorf("unexpected distribution: %d %d", count[a], count[b])
+	}
+	a.markDown()
+	if set.pick() != b {
+		t.Error("unhealthy replica should be skipped")
+	}
+}
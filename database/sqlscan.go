@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go_lib/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 结构体字段映射缓存，key为结构体类型，value为字段名到字段下标路径的映射
+var structFieldCache sync.Map
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// 获取结构体的字段映射
+// 字段名优先取db标签，其次json标签，都没有则使用下划线格式的字段名，标签为"-"时忽略
+// 匿名嵌入的结构体字段会被展开
+func structFields(t reflect.Type) map[string][]int {
+	if cache, ok := structFieldCache.Load(t); ok {
+		return cache.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	collectFields(t, nil, fields)
+	structFieldCache.Store(t, fields)
+	return fields
+}
+
+// 递归收集字段
+func collectFields(t reflect.Type, index []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		path := append(append([]int{}, index...), i)
+		name := fieldTagName(f)
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		// 嵌入的结构体，未指定标签时展开
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct && !reflect.PtrTo(ft).Implements(scannerType) {
+			collectFields(ft, path, fields)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = utils.Hump2Under(f.Name)
+		}
+		// 外层字段优先于嵌入结构体的字段
+		if exist, ok := fields[name]; ok && len(exist) <= len(path) {
+			continue
+		}
+		fields[name] = path
+	}
+}
+
+// 获取结构体中sql标签包含option的字段，字段名与structFields的规则相同
+func taggedColumns(data interface{}, option string) []string {
+	t := reflect.TypeOf(data)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var columns []string
+	for name, index := range structFields(t) {
+		for _, opt := range strings.Split(t.FieldByIndex(index).Tag.Get("sql"), ";") {
+			if strings.TrimSpace(opt) == option {
+				columns = append(columns, name)
+				break
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// 获取字段的标签名
+func fieldTagName(f reflect.StructField) string {
+	for _, key := range []string{"db", "json"} {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			name := strings.Split(tag, ",")[0]
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// 按下标路径获取字段，路径中的空指针会被初始化
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// 读取字段，路径中有空指针时返回false
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// 将结构体转换为写入的数据，字段名与扫描时的规则相同
+func structData(v reflect.Value) DM {
+	fields := structFields(v.Type())
+	data := make(DM, len(fields))
+	for name, index := range fields {
+		if f, ok := fieldValue(v, index); ok {
+			data[name] = f.Interface()
+		}
+	}
+	return data
+}
+
+// 设置扫描时是否严格匹配字段，严格模式下结果中存在结构体没有的字段时返回错误
+func (m *SqlDB) SetStrictScan(strict bool) {
+	m.strictScan = strict
+}
+
+// 查询并将结果扫描到dest中
+// dest可以是结构体指针，或结构体切片、结构体指针切片的指针
+func (m *SqlDB) QueryInto(dest interface{}, sqlStr string, args ...interface{}) error {
+	return m.QueryIntoContext(context.Background(), dest, sqlStr, args...)
+}
+
+// 查询并将结果扫描到dest中，支持上下文
+func (m *SqlDB) QueryIntoContext(ctx context.Context, dest interface{}, sqlStr string, args ...interface{}) error {
+	sqlStr = rebind(m.Dialect(), sqlStr)
+	ctx, event := m.beforeQuery(ctx, sqlStr, args)
+	rows, err := m.doQuery(ctx, sqlStr, args...)
+	if err != nil {
+		err = m.handleError(ctx, err)
+		m.afterQuery(ctx, event, -1, err)
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if err = m.ScanRows(rows, dest); err != nil {
+		err = m.handleError(ctx, err)
+	}
+	m.afterQuery(ctx, event, -1, err)
+	if err == nil {
+		if err = m.afterFind(ctx, dest); err != nil {
+			m.setLastError(err)
+		}
+	}
+	return err
+}
+
+// 将查询结果扫描到dest中
+func (m *SqlDB) ScanRows(rows *sql.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("database: scan destination must be a non-nil pointer")
+	}
+	v = v.Elem()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	// 扫描单条记录
+	if v.Kind() == reflect.Struct {
+		if !rows.Next() {
+			if err = rows.Err(); err != nil {
+				return err
+			}
+			return sql.ErrNoRows
+		}
+		return m.scanStruct(rows, columns, v)
+	}
+
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("database: unsupported scan destination %s", v.Type())
+	}
+	elemType := v.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("database: unsupported scan destination %s", v.Type())
+	}
+
+	list := reflect.MakeSlice(v.Type(), 0, 0)
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err = m.scanStruct(rows, columns, elem.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			list = reflect.Append(list, elem)
+		} else {
+			list = reflect.Append(list, elem.Elem())
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	v.Set(list)
+	return nil
+}
+
+// 扫描当前行到结构体中
+func (m *SqlDB) scanStruct(rows *sql.Rows, columns []string, v reflect.Value) error {
+	fields := structFields(v.Type())
+	scans := make([]interface{}, len(columns))
+	for i, c := range columns {
+		index, ok := fields[c]
+		if !ok {
+			if m.strictScan {
+				return fmt.Errorf("database: column %s has no matching field in %s", c, v.Type())
+			}
+			var empty interface{}
+			scans[i] = &empty
+			continue
+		}
+		field := fieldByIndex(v, index)
+		if field.Type() == timeType || (field.Kind() == reflect.Ptr && field.Type().Elem() == timeType) {
+			scans[i] = timeScanner{field}
+			continue
+		}
+		scans[i] = field.Addr().Interface()
+	}
+	return rows.Scan(scans...)
+}
+
+// 时间字段的扫描器，兼容以文本返回的时间，如未开启parseTime的MySQL
+type timeScanner struct {
+	v reflect.Value
+}
+
+func (s timeScanner) Scan(src interface{}) error {
+	var b []byte
+	switch val := src.(type) {
+	case nil:
+		s.v.Set(reflect.Zero(s.v.Type()))
+		return nil
+	case time.Time:
+		return s.set(val)
+	case []byte:
+		b = val
+	case string:
+		b = []byte(val)
+	default:
+		return fmt.Errorf("database: cannot scan %T into time", src)
+	}
+	t, ok := decodeTime(b).(time.Time)
+	if !ok {
+		return fmt.Errorf("database: cannot parse %q as time", b)
+	}
+	return s.set(t)
+}
+
+// 设置字段值，字段为指针时新建
+func (s timeScanner) set(t time.Time) error {
+	if s.v.Kind() == reflect.Ptr {
+		p := reflect.New(timeType)
+		p.Elem().Set(reflect.ValueOf(t))
+		s.v.Set(p)
+	} else {
+		s.v.Set(reflect.ValueOf(t))
+	}
+	return nil
+}
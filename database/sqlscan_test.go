@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+type scanBase struct {
+	Id int64 `db:"id"`
+}
+
+type scanUser struct {
+	scanBase
+	Name     string         `json:"name"`
+	Nickname *string        `db:"nickname"`
+	Remark   sql.NullString `db:"remark"`
+	Age      int
+	Ignore   string `db:"-"`
+}
+
+// 测试扫描到结构体切片
+func TestDBTable_Find(t *testing.T) {
+	db, fake := newFakeSqlDB("scan_find", MysqlDialect{})
+	fake.setRows([]string{"id", "name", "nickname", "remark", "age", "extra"},
+		[]driver.Value{int64(1), []byte("张三"), nil, "备注", int64(18), "x"},
+		[]driver.Value{int64(2), []byte("李四"), "小四", nil, int64(20), "y"},
+	)
+
+	var list []scanUser
+	if err := db.Table("t_user").Find(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Id != 1 || list[0].Name != "张三" || list[0].Nickname != nil || list[0].Remark.String != "备注" || list[0].Age != 18 {
+		t.Errorf("unexpected first row: %+v", list[0])
+	}
+	if list[1].Nickname == nil || *list[1].Nickname != "小四" || list[1].Remark.Valid {
+		t.Errorf("unexpected second row: %+v", list[1])
+	}
+
+	var user scanUser
+	if err := db.Table("t_user").First(&user); err != nil || user.Id != 1 {
+		t.Errorf("first failed: %+v %v", user, err)
+	}
+	if log := fake.log(); log[len(log)-1] != "SELECT * FROM `t_user`    LIMIT 1" {
+		t.Errorf("unexpected sql: %s", log[len(log)-1])
+	}
+
+	// 严格模式下未映射的字段返回错误
+	db.SetStrictScan(true)
+	var ptrList []*scanUser
+	if err := db.QueryInto(&ptrList, "SELECT * FROM t_user"); err == nil {
+		t.Error("strict scan should report unmapped column extra")
+	}
+
+	fake.setRows([]string{"id"})
+	if err := db.Table("t_user").First(&user); err != sql.ErrNoRows {
+		t.Errorf("expect sql.ErrNoRows, got %v", err)
+	}
+}
+
+type dbTagUser struct {
+	Id    int64  `db:"id"`
+	Name  string `db:"user_name" json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	Age   int
+	note  string
+}
+
+// 测试写入与扫描使用相同的字段名规则
+func TestConvertData_StructFields(t *testing.T) {
+	db, fake := newFakeSqlDB("scan_round_trip", MysqlDialect{})
+
+	u := dbTagUser{Id: 1, Name: "a", Email: "a@b.c", Age: 18, note: "x"}
+	if _, ok := db.Insert("t_user", &u); !ok {
+		t.Fatal(db.GetLastError())
+	}
+	expect := "INSERT INTO `t_user`(`age`,`email`,`id`,`user_name`) VALUES(?,?,?,?)"
+	if log := fake.log(); log[0] != expect {
+		t.Fatalf("unexpected sql: %s", log[0])
+	}
+
+	fake.setRows([]string{"age", "email", "id", "user_name"}, fake.args[0])
+	var res dbTagUser
+	if err := db.Table("t_user").First(&res); err != nil {
+		t.Fatal(err)
+	}
+	u.note = ""
+	if res != u {
+		t.Errorf("unexpected row: %+v", res)
+	}
+}
@@ -0,0 +1,764 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go_lib/utils"
+	"hash/crc32"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 分片，即一张物理表
+type Shard struct {
+	DB    *SqlDB // 所在的数据库，nil表示注册规则的数据库
+	Table string // 物理表名
+}
+
+// 分表规则
+type ShardRule interface {
+	// 分片键
+	Column() string
+	// 根据分片键的值定位分片，table为逻辑表名
+	Locate(table string, value interface{}) (Shard, error)
+	// 全部分片，用于没有分片键条件时的扇出查询
+	Shards(table string) []Shard
+}
+
+// 注册逻辑表的分表规则，需要在使用该表前注册，规则的配置不合法时返回错误
+func (m *SqlDB) RegisterShard(table string, rule ShardRule) error {
+	if v, ok := rule.(shardValidator); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+	if m.shards == nil {
+		m.shards = make(map[string]ShardRule)
+	}
+	m.shards[table] = rule
+	return nil
+}
+
+// 注册时检查配置的分表规则
+type shardValidator interface {
+	validate() error
+}
+
+// 获取逻辑表的分表规则，未分表时返回nil
+func (m *SqlDB) shardRule(table string) ShardRule {
+	return m.shards[table]
+}
+
+// 取模分表，如 orders_00 ~ orders_63
+type ModuloShard struct {
+	Key    string   // 分片键，值为整数或字符串，字符串取crc32后取模
+	Count  int      // 分片数量
+	Format string   // 物理表名格式，参数为逻辑表名及序号，默认为"%s_%02d"
+	DBs    []*SqlDB // 分库，第i个分片位于DBs[i%len(DBs)]，为空时使用当前数据库
+}
+
+func (s *ModuloShard) Column() string {
+	return s.Key
+}
+
+func (s *ModuloShard) Locate(table string, value interface{}) (Shard, error) {
+	if err := s.validate(); err != nil {
+		return Shard{}, err
+	}
+	var n uint64
+	switch val := value.(type) {
+	case string:
+		n = uint64(crc32.ChecksumIEEE([]byte(val)))
+	case []byte:
+		n = uint64(crc32.ChecksumIEEE(val))
+	default:
+		i, ok := toInt64(toNumber(value))
+		if !ok {
+			return Shard{}, fmt.Errorf("database: invalid shard key %v", value)
+		}
+		// 按uint64取绝对值，避免MinInt64取反溢出
+		n = uint64(i)
+		if i < 0 {
+			n = -n
+		}
+	}
+	return s.shard(table, int(n%uint64(s.Count))), nil
+}
+
+func (s *ModuloShard) Shards(table string) []Shard {
+	shards := make([]Shard, 0, s.Count)
+	for i := 0; i < s.Count; i++ {
+		shards = append(shards, s.shard(table, i))
+	}
+	return shards
+}
+
+func (s *ModuloShard) validate() error {
+	if s.Count <= 0 {
+		return fmt.Errorf("database: invalid shard count %d", s.Count)
+	}
+	return nil
+}
+
+func (s *ModuloShard) shard(table string, index int) Shard {
+	format := s.Format
+	if format == "" {
+		format = "%s_%02d"
+	}
+	shard := Shard{Table: fmt.Sprintf(format, table, index)}
+	if len(s.DBs) > 0 {
+		shard.DB = s.DBs[index%len(s.DBs)]
+	}
+	return shard
+}
+
+// 范围分片的区间，包含Min，不包含Max
+type ShardRange struct {
+	Min   int64
+	Max   int64
+	Table string // 物理表名
+	DB    *SqlDB // 所在的数据库，nil表示当前数据库
+}
+
+// 范围分表，如 id在[0, 1000000)的数据位于orders_0
+type RangeShard struct {
+	Key    string
+	Ranges []ShardRange
+}
+
+func (s *RangeShard) Column() string {
+	return s.Key
+}
+
+func (s *RangeShard) Locate(table string, value interface{}) (Shard, error) {
+	i, ok := toInt64(toNumber(value))
+	if !ok {
+		return Shard{}, fmt.Errorf("database: invalid shard key %v", value)
+	}
+	for _, r := range s.Ranges {
+		if i >= r.Min && i < r.Max {
+			return Shard{DB: r.DB, Table: r.Table}, nil
+		}
+	}
+	return Shard{}, fmt.Errorf("database: shard key %d out of range for %s", i, table)
+}
+
+func (s *RangeShard) Shards(table string) []Shard {
+	shards := make([]Shard, 0, len(s.Ranges))
+	for _, r := range s.Ranges {
+		shards = append(shards, Shard{DB: r.DB, Table: r.Table})
+	}
+	return shards
+}
+
+// 日期分片的单位
+const (
+	ShardByDay   = "day"
+	ShardByMonth = "month"
+	ShardByYear  = "year"
+)
+
+// 按日期分表，如 orders_202001
+type DateShard struct {
+	Key      string
+	Unit     string         // 分片单位：day、month、year，默认month
+	Format   string         // 表名后缀的时间格式，默认按单位为20060102、200601、2006
+	Start    time.Time      // 第一个分片的时间，用于扇出查询
+	End      time.Time      // 最后一个分片的时间，零值表示当前时间
+	Location *time.Location // 时区，默认为time.Local
+}
+
+func (s *DateShard) Column() string {
+	return s.Key
+}
+
+func (s *DateShard) Locate(table string, value interface{}) (Shard, error) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	var t time.Time
+	switch val := value.(type) {
+	case time.Time:
+		t = val
+	case string:
+		var err error
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02", time.RFC3339} {
+			if t, err = time.ParseInLocation(layout, val, loc); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return Shard{}, fmt.Errorf("database: invalid shard key %v", value)
+		}
+	default:
+		i, ok := toInt64(toNumber(value))
+		if !ok {
+			return Shard{}, fmt.Errorf("database: invalid shard key %v", value)
+		}
+		t = time.Unix(i, 0)
+	}
+	return Shard{Table: table + "_" + t.In(loc).Format(s.format())}, nil
+}
+
+func (s *DateShard) Shards(table string) []Shard {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	end := s.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	end = s.truncate(end.In(loc))
+	var shards []Shard
+	for t := s.truncate(s.Start.In(loc)); !t.After(end); t = s.next(t) {
+		shards = append(shards, Shard{Table: table + "_" + t.Format(s.format())})
+	}
+	return shards
+}
+
+// 扇出查询从Start开始，零值会遍历公元1年以来的全部分片
+func (s *DateShard) validate() error {
+	if s.Start.IsZero() {
+		return errors.New("database: shard start time is required")
+	}
+	return nil
+}
+
+// 表名后缀的时间格式
+func (s *DateShard) format() string {
+	if s.Format != "" {
+		return s.Format
+	}
+	switch s.Unit {
+	case ShardByDay:
+		return "20060102"
+	case ShardByYear:
+		return "2006"
+	}
+	return "200601"
+}
+
+// 截取到分片单位的起始时间
+func (s *DateShard) truncate(t time.Time) time.Time {
+	switch s.Unit {
+	case ShardByDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case ShardByYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// 下一个分片的时间
+func (s *DateShard) next(t time.Time) time.Time {
+	switch s.Unit {
+	case ShardByDay:
+		return t.AddDate(0, 0, 1)
+	case ShardByYear:
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+// 将整数及数字字符串统一转换为int64
+func toNumber(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int8:
+		return int64(val)
+	case int16:
+		return int64(val)
+	case uint:
+		return int64(val)
+	case uint8:
+		return int64(val)
+	case uint16:
+		return int64(val)
+	case uint32:
+		return int64(val)
+	case string:
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return i
+		}
+	}
+	return v
+}
+
+// 从条件中获取分片键的值，只处理AND连接的等于及IN条件
+func shardKeyValues(wheres []utils.M, column string) ([]interface{}, bool) {
+	for _, where := range wheres {
+		if values, ok := whereKeyValues(where, column); ok {
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+// 从单组条件中获取分片键的值，只查找AND连接的条件
+func whereKeyValues(where utils.M, column string) ([]interface{}, bool) {
+	for k, v := range where {
+		switch k {
+		case column, column + "[=]":
+			if list, ok := sliceValues(v); ok {
+				return list, true
+			}
+			return []interface{}{v}, true
+		case "AND":
+			if sub, ok := v.(utils.M); ok {
+				if values, ok := whereKeyValues(sub, column); ok {
+					return values, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// 获取本次操作涉及的分片，未分表时返回nil
+func (t *DBTable) shards() ([]Shard, error) {
+	rule := t.db.shardRule(t.table)
+	if rule == nil {
+		return nil, nil
+	}
+	values, ok := shardKeyValues(t.where, rule.Column())
+	if !ok {
+		return rule.Shards(t.table), nil
+	}
+	var shards []Shard
+	exist := make(map[Shard]bool)
+	for _, v := range values {
+		shard, err := rule.Locate(t.table, v)
+		if err != nil {
+			return nil, err
+		}
+		if !exist[shard] {
+			exist[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+// 获取分片所在的数据库，与当前数据库使用同一连接池时使用当前数据库，以便参与事务
+func (t *DBTable) shardDB(shard Shard) *SqlDB {
+	if shard.DB == nil || shard.DB.db == t.db.db {
+		return t.db
+	}
+	return shard.DB
+}
+
+// 根据数据中的分片键定位新增的数据库及表
+func (t *DBTable) locate(data interface{}) (*SqlDB, string, error) {
+	rule := t.db.shardRule(t.table)
+	if rule == nil {
+		return t.db, t.table, nil
+	}
+	dm, err := ConvertData(data)
+	if err != nil {
+		return nil, "", err
+	}
+	value, ok := dm[rule.Column()]
+	if !ok {
+		return nil, "", fmt.Errorf("database: shard key %s is required for %s", rule.Column(), t.table)
+	}
+	shard, err := rule.Locate(t.table, value)
+	if err != nil {
+		return nil, "", err
+	}
+	return t.shardDB(shard), shard.Table, nil
+}
+
+// 在各个分片上执行fn，from为带逻辑表名别名的物理表，多个分片时并发执行，事务中依次执行
+func (t *DBTable) fanOut(shards []Shard, fn func(i int, db *SqlDB, from string) error) error {
+	from := func(shard Shard) string {
+		return t.db.FormatColumn(shard.Table) + " " + t.db.FormatColumn(t.table)
+	}
+	if t.db.tx != nil {
+		for _, shard := range shards {
+			if shard.DB != nil && shard.DB.db != t.db.db {
+				return errors.New("database: cross-database shards are not supported in a transaction")
+			}
+		}
+	}
+	if len(shards) == 1 || t.db.tx != nil {
+		for i, shard := range shards {
+			if err := fn(i, t.shardDB(shard), from(shard)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		// 各协程使用SqlDB的副本，避免同时修改LastSql等字段
+		db := t.shardDB(shard).clone()
+		go func(i int, db *SqlDB, from string) {
+			defer wg.Done()
+			errs[i] = fn(i, db, from)
+		}(i, db, from(shard))
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在所有涉及的分片上执行写操作
+func (t *DBTable) eachShard(fn func(db *SqlDB, table string) error) bool {
+	shards, err := t.shards()
+	if err == nil {
+		if shards == nil {
+			shards = []Shard{{Table: t.table}}
+		}
+		err = t.fanOut(shards, func(i int, db *SqlDB, from string) error {
+			return fn(db, shards[i].Table)
+		})
+	}
+	if err != nil {
+		t.db.setLastError(err)
+	}
+	return err == nil
+}
+
+// 分片查询的分页，多个分片时每个分片查询offset+size条，合并后再分页
+func (t *DBTable) shardLimit(shards []Shard) string {
+	if len(shards) == 1 || t.limitSize <= 0 {
+		return t.limitStr
+	}
+	return t.db.Dialect().Limit(t.limitFrom+t.limitSize, 0)
+}
+
+// 获取合并后分页的起止下标
+func (t *DBTable) pageRange(total int) (int, int) {
+	if t.limitSize <= 0 {
+		return 0, total
+	}
+	start, end := t.limitFrom, t.limitFrom+t.limitSize
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// 聚合函数
+var aggregateReg = regexp.MustCompile(`(?i)\b(COUNT|SUM|AVG|MIN|MAX|GROUP_CONCAT|STRING_AGG|ARRAY_AGG)\s*\(`)
+
+// 多个分片的结果只能拼接，分组及聚合查询需要定位到单个分片
+func (t *DBTable) checkFanOut(shards []Shard) error {
+	if len(shards) <= 1 {
+		return nil
+	}
+	if t.groupStr != "" || t.havingStr != "" || aggregateReg.MatchString(t.fieldStr) {
+		err := fmt.Errorf("database: group or aggregate query on %s needs the shard key to locate a single shard", t.table)
+		t.db.setLastError(err)
+		return err
+	}
+	return nil
+}
+
+// 查询分片并合并结果
+func (t *DBTable) shardResult(shards []Shard) ([]utils.M, error) {
+	if err := t.checkFanOut(shards); err != nil {
+		return nil, err
+	}
+	limitStr := t.shardLimit(shards)
+	results := make([][]utils.M, len(shards))
+	err := t.fanOut(shards, func(i int, db *SqlDB, from string) error {
+		list, err := db.QueryContext(t.context(), t.selectSql(from, limitStr), t.queryArgs()...)
+		results[i] = list
+		return err
+	})
+	if err != nil {
+		t.db.setLastError(err)
+		return nil, err
+	}
+	if len(shards) == 1 {
+		return results[0], nil
+	}
+	var list []utils.M
+	for _, r := range results {
+		list = append(list, r...)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return t.less(func(c string) interface{} { return list[i][c] }, func(c string) interface{} { return list[j][c] })
+	})
+	start, end := t.pageRange(len(list))
+	return list[start:end], nil
+}
+
+// 查询分片并将合并的结果扫描到dest中
+func (t *DBTable) shardFind(shards []Shard, dest interface{}) error {
+	if len(shards) == 1 {
+		db := t.shardDB(shards[0])
+		from := t.db.FormatColumn(shards[0].Table) + " " + t.db.FormatColumn(t.table)
+		err := db.QueryIntoContext(t.context(), dest, t.selectSql(from, t.limitStr), t.queryArgs()...)
+		if err != nil && db != t.db {
+			t.db.setLastError(err)
+		}
+		return err
+	}
+	if err := t.checkFanOut(shards); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("database: scan destination must be a non-nil pointer")
+	}
+	v = v.Elem()
+	single := v.Kind() == reflect.Struct
+	sliceType := v.Type()
+	if single {
+		sliceType = reflect.SliceOf(v.Type())
+	}
+	limitStr := t.shardLimit(shards)
+	parts := make([]reflect.Value, len(shards))
+	err := t.fanOut(shards, func(i int, db *SqlDB, from string) error {
+		part := reflect.New(sliceType)
+		parts[i] = part.Elem()
+		return db.QueryIntoContext(t.context(), part.Interface(), t.selectSql(from, limitStr), t.queryArgs()...)
+	})
+	if err != nil {
+		t.db.setLastError(err)
+		return err
+	}
+
+	list := reflect.MakeSlice(sliceType, 0, 0)
+	for _, part := range parts {
+		list = reflect.AppendSlice(list, part)
+	}
+	elemType := sliceType.Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	fields := structFields(elemType)
+	field := func(i int) func(c string) interface{} {
+		return func(c string) interface{} {
+			index, ok := fields[c]
+			if !ok {
+				return nil
+			}
+			return reflect.Indirect(list.Index(i)).FieldByIndex(index).Interface()
+		}
+	}
+	// reflect.Swapper在交换元素时保持与list一致
+	swap := reflect.Swapper(list.Interface())
+	sort.Stable(&sortable{n: list.Len(), less: func(i, j int) bool { return t.less(field(i), field(j)) }, swap: swap})
+
+	start, end := t.pageRange(list.Len())
+	list = list.Slice(start, end)
+	if !single {
+		v.Set(list)
+		return nil
+	}
+	if list.Len() == 0 {
+		return sql.ErrNoRows
+	}
+	v.Set(list.Index(0))
+	return nil
+}
+
+// 查询所有分片的记录条数之和
+func (t *DBTable) shardRows(shards []Shard) (int, error) {
+	if err := t.checkFanOut(shards); err != nil {
+		return 0, err
+	}
+	counts := make([]int, len(shards))
+	err := t.fanOut(shards, func(i int, db *SqlDB, from string) error {
+		var err error
+		counts[i], err = t.count(db, from)
+		return err
+	})
+	if err != nil {
+		t.db.setLastError(err)
+		return 0, err
+	}
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	return total, nil
+}
+
+// 分片结果的批量新增，按分片键拆分后分别新增
+func (t *DBTable) shardInsertMany(rule ShardRule, rows interface{}, opts ...*InsertManyOptions) (*InsertManyResult, error) {
+	columns, values, err := t.db.batchValues(t.table, rows)
+	if err != nil {
+		return nil, err
+	}
+	key := -1
+	for i, c := range columns {
+		if c == rule.Column() {
+			key = i
+		}
+	}
+	if key == -1 && len(values) > 0 {
+		return nil, fmt.Errorf("database: shard key %s is required for %s", rule.Column(), t.table)
+	}
+
+	// 按分片分组，保持数据的顺序
+	var shards []Shard
+	groups := make(map[Shard][]int)
+	for i, row := range values {
+		shard, err := rule.Locate(t.table, row[key])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[shard]; !ok {
+			shards = append(shards, shard)
+		}
+		groups[shard] = append(groups[shard], i)
+	}
+
+	result := &InsertManyResult{Ids: make([]int, len(values))}
+	var firstErr error
+	for _, shard := range shards {
+		index := groups[shard]
+		data := make([]DM, 0, len(index))
+		for _, i := range index {
+			dm := make(DM, len(columns))
+			for j, c := range columns {
+				dm[c] = values[i][j]
+			}
+			data = append(data, dm)
+		}
+		res, err := t.shardDB(shard).insertMany(t.context(), shard.Table, data, t.pk, opts...)
+		if res != nil {
+			for j, id := range res.Ids {
+				result.Ids[index[j]] = id
+			}
+			result.Rows += res.Rows
+			result.Errors = append(result.Errors, res.Errors...)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		t.db.setLastError(firstErr)
+	}
+	return result, firstErr
+}
+
+// 按排序字段比较两条记录
+func (t *DBTable) less(a, b func(column string) interface{}) bool {
+	for _, o := range t.orders {
+		c := compareValues(a(o.column), b(o.column))
+		if c == 0 {
+			continue
+		}
+		if o.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// 通用的排序实现
+type sortable struct {
+	n    int
+	less func(i, j int) bool
+	swap func(i, j int)
+}
+
+func (s *sortable) Len() int           { return s.n }
+func (s *sortable) Less(i, j int) bool { return s.less(i, j) }
+func (s *sortable) Swap(i, j int)      { s.swap(i, j) }
+
+// 比较两个值，nil最小
+func compareValues(a, b interface{}) int {
+	a, b = indirectValue(a), indirectValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	switch va := a.(type) {
+	case time.Time:
+		if vb, ok := b.(time.Time); ok {
+			switch {
+			case va.Before(vb):
+				return -1
+			case va.After(vb):
+				return 1
+			}
+			return 0
+		}
+	case []byte:
+		if vb, ok := b.([]byte); ok {
+			return bytes.Compare(va, vb)
+		}
+	}
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case sa < sb:
+		return -1
+	case sa > sb:
+		return 1
+	}
+	return 0
+}
+
+// 获取指针指向的值，空指针返回nil
+func indirectValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+// 转换为float64，Decimal按数字处理
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	if d, ok := v.(Decimal); ok {
+		f, err := d.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
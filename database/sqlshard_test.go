@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试按分片键路由
+func TestDBTable_Shard(t *testing.T) {
+	db, fake := newFakeSqlDB("shard", MysqlDialect{})
+	db.RegisterShard("orders", &ModuloShard{Key: "user_id", Count: 64})
+	fake.setRows([]string{"id"}, []driver.Value{int64(1)})
+
+	_, _ = db.Table("orders").Where(utils.M{"user_id": 42}, "").Query().Result()
+	_ = db.Table("orders").Where(utils.M{"status": 1}, "").Where(utils.M{"user_id": 106}, "").Update(utils.M{"status": 2})
+	_, _ = db.Table("orders").Insert(utils.M{"user_id": 43, "amount": 10})
+	expect := []string{
+		"SELECT * FROM `orders_42` `orders`  WHERE (`orders`.`user_id` = ?)  ",
+		"UPDATE `orders_42` SET `status` = ? WHERE (`orders_42`.`status` = ?) AND (`orders_42`.`user_id` = ?)",
+		"INSERT INTO `orders_43`(`amount`,`user_id`) VALUES(?,?)",
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+
+	if _, ok := db.Table("orders").Insert(utils.M{"amount": 10}); ok {
+		t.Error("insert without shard key should fail")
+	}
+}
+
+// 测试扇出查询合并排序及分页
+func TestDBTable_ShardFanOut(t *testing.T) {
+	db, fake := newFakeSqlDB("shard_fan_out", MysqlDialect{})
+	other, otherFake := newFakeSqlDB("shard_fan_out_other", MysqlDialect{})
+	db.RegisterShard("orders", &ModuloShard{Key: "user_id", Count: 2, DBs: []*SqlDB{db, other}})
+	fake.setRows([]string{"id", "user_id"}, []driver.Value{int64(6), int64(2)}, []driver.Value{int64(2), int64(4)})
+	otherFake.setRows([]string{"id", "user_id"}, []driver.Value{int64(5), int64(1)}, []driver.Value{int64(3), int64(3)})
+
+	list, err := db.Table("orders").OrderBy("id DESC").Limit(2, 2).Query().Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0]["id"] != int64(3) || list[1]["id"] != int64(2) {
+		t.Errorf("unexpected result: %v", list)
+	}
+	if log := fake.log(); log[0] != "SELECT * FROM `orders_00` `orders`   ORDER BY `orders`.`id` DESC LIMIT 4" {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if log := otherFake.log(); log[0] != "SELECT * FROM `orders_01` `orders`   ORDER BY `orders`.`id` DESC LIMIT 4" {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+
+	type order struct {
+		Id     int64 `db:"id"`
+		UserId int64 `db:"user_id"`
+	}
+	var orders []order
+	if err = db.Table("orders").OrderBy("user_id").Find(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 4 || orders[0].UserId != 1 || orders[3].UserId != 4 {
+		t.Errorf("unexpected result: %v", orders)
+	}
+	var first order
+	if err = db.Table("orders").OrderBy("id").First(&first); err != nil || first.Id != 2 {
+		t.Errorf("unexpected result: %v %v", first, err)
+	}
+
+	// 条件中的多个分片键只查询对应的分片
+	_, _ = db.Table("orders").Where(utils.M{"user_id": []int{2, 4}}, "").Query().Result()
+	if log := otherFake.log(); len(log) != 3 {
+		t.Errorf("unexpected sql: %q", log)
+	}
+
+	// 分组及聚合查询不能合并多个分片的结果
+	if _, err = db.Table("orders").Select(utils.M{"user_id": "", "id[COUNT] n": ""}).Group("user_id").Query().Result(); err == nil {
+		t.Error("group on multiple shards should fail")
+	}
+	var total struct {
+		N int64 `db:"n"`
+	}
+	if err = db.Table("orders").Select(utils.M{"amount[SUM] n": ""}).Query().Find(&total); err == nil || db.GetLastError() != err {
+		t.Errorf("aggregate on multiple shards should fail: %v", err)
+	}
+	if _, err = db.Table("orders").Select(utils.M{"user_id": "", "id[COUNT] n": ""}).Where(utils.M{"user_id": 1}, "").Group("user_id").Query().Result(); err != nil {
+		t.Errorf("group on a single shard failed: %v", err)
+	}
+	if rows := db.Table("orders").Group("status").Rows(); rows != 0 || db.GetLastError() == nil {
+		t.Errorf("group count on multiple shards should fail: %d", rows)
+	}
+
+	// 批量新增按分片拆分
+	res, err := db.Table("orders").InsertMany([]utils.M{{"user_id": 1}, {"user_id": 2}, {"user_id": 3}})
+	if err != nil || res.Rows != 3 {
+		t.Fatalf("insert many failed: %v %v", res, err)
+	}
+	log := otherFake.log()
+	if log[len(log)-1] != "INSERT INTO `orders_01`(`user_id`) VALUES(?),(?)" {
+		t.Errorf("unexpected sql: %s", log[len(log)-1])
+	}
+}
+
+// 测试范围及日期分片
+func TestShardRule(t *testing.T) {
+	rangeRule := &RangeShard{Key: "id", Ranges: []ShardRange{{Min: 0, Max: 100, Table: "logs_0"}, {Min: 100, Max: 200, Table: "logs_1"}}}
+	if shard, err := rangeRule.Locate("logs", 150); err != nil || shard.Table != "logs_1" {
+		t.Errorf("unexpected shard: %v %v", shard, err)
+	}
+	modRule := &ModuloShard{Key: "id", Count: 3}
+	if shard, err := modRule.Locate("logs", int64(math.MinInt64)); err != nil || shard.Table != "logs_02" {
+		t.Errorf("unexpected shard: %v %v", shard, err)
+	}
+	if _, err := rangeRule.Locate("logs", 200); err == nil {
+		t.Error("out of range should fail")
+	}
+
+	dateRule := &DateShard{Key: "created_at", Start: time.Date(2020, 11, 15, 0, 0, 0, 0, time.UTC), End: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), Location: time.UTC}
+	if shard, err := dateRule.Locate("logs", "2020-12-31 23:00:00"); err != nil || shard.Table != "logs_202012" {
+		t.Errorf("unexpected shard: %v %v", shard, err)
+	}
+	var tables []string
+	for _, s := range dateRule.Shards("logs") {
+		tables = append(tables, s.Table)
+	}
+	if strings.Join(tables, ",") != "logs_202011,logs_202012,logs_202101,logs_202102" {
+		t.Errorf("unexpected shards: %v", tables)
+	}
+}
+
+// 测试注册时检查分表规则的配置
+func TestSqlDB_RegisterShard(t *testing.T) {
+	db, _ := newFakeSqlDB("register_shard", MysqlDialect{})
+	if err := db.RegisterShard("orders", &ModuloShard{Key: "user_id"}); err == nil {
+		t.Error("zero shard count should fail")
+	}
+	if err := db.RegisterShard("logs", &DateShard{Key: "created_at"}); err == nil {
+		t.Error("zero start time should fail")
+	}
+	if db.shardRule("orders") != nil || db.shardRule("logs") != nil {
+		t.Error("invalid rules should not be registered")
+	}
+	if _, err := (&ModuloShard{Key: "user_id"}).Locate("orders", 1); err == nil {
+		t.Error("locate with zero shard count should fail")
+	}
+	if err := db.RegisterShard("orders", &ModuloShard{Key: "user_id", Count: 2}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+	"go_lib/utils"
+)
+
+// 软删除记录的查询范围
+const (
+	trashedExclude = iota // 不包含已删除的记录
+	trashedWith           // 包含已删除的记录
+	trashedOnly           // 只查询已删除的记录
+)
+
+// 设置表为软删除，column为删除时间字段，为空时使用deleted_at
+// 设置后Delete更新删除时间，查询、统计及更新自动添加删除时间为NULL的条件
+func (m *SqlDB) RegisterSoftDelete(table string, column string) {
+	if column == "" {
+		column = "deleted_at"
+	}
+	if m.softDeletes == nil {
+		m.softDeletes = make(map[string]string)
+	}
+	m.softDeletes[table] = column
+}
+
+// 获取表的删除时间字段，不是软删除的表返回空
+func (m *SqlDB) softDeleteColumn(table string) string {
+	return m.softDeletes[table]
+}
+
+// 本次操作包含已删除的记录
+func (t *DBTable) WithTrashed() *DBTable {
+	t.trashed = trashedWith
+	return t
+}
+
+// 本次操作只包含已删除的记录
+func (t *DBTable) OnlyTrashed() *DBTable {
+	t.trashed = trashedOnly
+	return t
+}
+
+// 添加软删除条件，每次操作只添加一次
+func (t *DBTable) applySoftDelete() {
+	column := t.db.softDeleteColumn(t.table)
+	if column == "" || t.scoped || t.trashed == trashedWith {
+		return
+	}
+	t.scoped = true
+	if t.trashed == trashedOnly {
+		t.Where(utils.M{column + "[!]": nil}, "")
+	} else {
+		t.Where(utils.M{column: nil}, "")
+	}
+}
+
+// 恢复已删除的记录
+func (t *DBTable) Restore() bool {
+	column := t.db.softDeleteColumn(t.table)
+	if column == "" {
+		t.err = fmt.Errorf("database: %s is not a soft delete table", t.table)
+		t.db.setLastError(t.err)
+		t.Clear()
+		return false
+	}
+	return t.OnlyTrashed().update(utils.M{column: nil})
+}
+
+// 物理删除，软删除的表也直接删除记录
+func (t *DBTable) ForceDelete() bool {
+	defer t.Clear()
+	if t.checkError() != nil {
+		return false
+	}
+	if err := t.db.beforeDelete(t.context(), t.model); err != nil {
+		t.db.setLastError(err)
+		return false
+	}
+	return t.eachShard(func(db *SqlDB, table string) error {
+		_, err := db.delete(t.context(), t.where, table)
+		return err
+	})
+}
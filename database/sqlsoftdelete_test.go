@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试软删除
+func TestDBTable_SoftDelete(t *testing.T) {
+	db, fake := newFakeSqlDB("soft_delete", MysqlDialect{})
+	fake.setRows([]string{"count(*)"}, []driver.Value{int64(1)})
+	db.RegisterSoftDelete("t_user", "")
+
+	table := db.Table("t_user")
+	_, _ = table.Where(utils.M{"id": 1}, "").Query().Result()
+	table.Where(utils.M{"status": 1}, "").Rows()
+	table.Where(utils.M{"id": 1}, "").Update(utils.M{"name": "a"})
+	table.Where(utils.M{"id": 1}, "").Delete()
+	_, _ = table.WithTrashed().Query().Result()
+	_, _ = table.OnlyTrashed().Paginate(1, 10)
+	table.Where(utils.M{"id": 1}, "").Restore()
+	table.Where(utils.M{"id": 1}, "").ForceDelete()
+
+	expect := []string{
+		"SELECT * FROM `t_user` WHERE (`t_user`.`id` = ?) AND (`t_user`.`deleted_at` IS NULL)",
+		"SELECT count(*) FROM `t_user` WHERE (`t_user`.`status` = ?) AND (`t_user`.`deleted_at` IS NULL)",
+		"UPDATE `t_user` SET `name` = ? WHERE (`t_user`.`id` = ?) AND (`t_user`.`deleted_at` IS NULL)",
+		"UPDATE `t_user` SET `deleted_at` = ? WHERE (`t_user`.`id` = ?) AND (`t_user`.`deleted_at` IS NULL)",
+		"SELECT * FROM `t_user`",
+		"SELECT count(*) FROM `t_user` WHERE (`t_user`.`deleted_at` IS NOT NULL)",
+		"SELECT * FROM `t_user` WHERE (`t_user`.`deleted_at` IS NOT NULL) LIMIT 10",
+		"UPDATE `t_user` SET `deleted_at` = ? WHERE (`t_user`.`id` = ?) AND (`t_user`.`deleted_at` IS NOT NULL)",
+		"DELETE FROM `t_user` WHERE (`t_user`.`id` = ?)",
+	}
+	log := fake.log()
+	if len(log) != len(expect) {
+		t.Fatalf("unexpected sql: %q", log)
+	}
+	for i := range expect {
+		if normalizeSpace(log[i]) != expect[i] {
+			t.Errorf("unexpected sql %d: %s", i, log[i])
+		}
+	}
+	if _, ok := fake.args[3][0].(time.Time); !ok {
+		t.Errorf("unexpected delete args: %v", fake.args[3])
+	}
+	if fake.args[7][0] != nil {
+		t.Errorf("unexpected restore args: %v", fake.args[7])
+	}
+
+	// 不是软删除的表
+	if db.Table("t_order").Restore() || db.GetLastError() == nil {
+		t.Error("restore should fail on a normal table")
+	}
+	db.Table("t_order").Where(utils.M{"id": 1}, "").Delete()
+	if log := fake.log(); log[len(log)-1] != "DELETE FROM `t_order` WHERE (`t_order`.`id` = ?)" {
+		t.Errorf("unexpected sql: %s", log[len(log)-1])
+	}
+}
+
+// 合并SQL中的连续空格
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+)
+
+// 预处理语句缓存统计
+type StmtCacheStats struct {
+	Size      int    // 当前缓存数量
+	Capacity  int    // 缓存上限
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中次数
+	Evictions uint64 // 淘汰次数
+}
+
+// 缓存的预处理语句
+type stmtEntry struct {
+	key     stmtKey
+	stmt    *sql.Stmt
+	refs    int  // 正在使用的数量
+	evicted bool // 是否已被淘汰
+}
+
+// 缓存键，预处理语句与连接池绑定，主库与从库分别缓存
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+// 预处理语句LRU缓存
+type stmtCache struct {
+	mu       sync.Mutex
+	capacity int
+	list     *list.List
+	items    map[stmtKey]*list.Element
+	stats    StmtCacheStats
+}
+
+// 新建预处理语句缓存
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{capacity: capacity, list: list.New(), items: make(map[stmtKey]*list.Element)}
+}
+
+// 获取预处理语句，使用完后必须调用release
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string) (*stmtEntry, error) {
+	key := stmtKey{db: db, query: query}
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.list.MoveToFront(el)
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 其他协程已经缓存了相同的语句
+	if el, ok := c.items[key]; ok {
+		_ = stmt.Close()
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		return entry, nil
+	}
+	entry := &stmtEntry{key: key, stmt: stmt, refs: 1}
+	c.items[key] = c.list.PushFront(entry)
+	for c.list.Len() > c.capacity {
+		c.evict(c.list.Back())
+	}
+	return entry, nil
+}
+
+// 释放预处理语句，已淘汰且不再使用时关闭
+func (c *stmtCache) release(entry *stmtEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+// 淘汰缓存
+func (c *stmtCache) evict(el *list.Element) {
+	entry := el.Value.(*stmtEntry)
+	c.list.Remove(el)
+	delete(c.items, entry.key)
+	entry.evicted = true
+	c.stats.Evictions++
+	if entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+// 清空缓存
+func (c *stmtCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.list.Len() > 0 {
+		c.evict(c.list.Back())
+	}
+}
+
+// 获取统计信息
+func (c *stmtCache) statistics() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.list.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// 设置预处理语句缓存的数量，0表示关闭缓存
+func (m *SqlDB) SetStmtCache(capacity int) {
+	if m.stmts != nil {
+		m.stmts.clear()
+	}
+	if capacity <= 0 {
+		m.stmts = nil
+		return
+	}
+	m.stmts = newStmtCache(capacity)
+}
+
+// 获取预处理语句缓存的统计信息
+func (m *SqlDB) StmtCacheStats() StmtCacheStats {
+	if m.stmts == nil {
+		return StmtCacheStats{}
+	}
+	return m.stmts.statistics()
+}
+
+// 获取缓存的预处理语句，db为主库且在事务中时返回绑定到该事务的语句
+func (m *SqlDB) prepare(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, func(), error) {
+	entry, err := m.stmts.get(ctx, db, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		m.stmts.release(entry)
+	}
+	if m.tx != nil && db == m.db {
+		// 事务结束时会自动关闭
+		return m.tx.StmtContext(ctx, entry.stmt), release, nil
+	}
+	return entry.stmt, release, nil
+}
+
+// 获取执行器，db为主库时在事务中使用事务执行
+func (m *SqlDB) executorOf(db *sql.DB) executor {
+	if db == m.db {
+		return m.executor()
+	}
+	return db
+}
+
+// 执行SQL，开启缓存时使用预处理语句
+func (m *SqlDB) doExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if m.stmts == nil {
+		return m.executor().ExecContext(ctx, query, args...)
+	}
+	stmt, release, err := m.prepare(ctx, m.db, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+// 查询，只读语句优先使用从库，从库连接失败时改为查询主库
+func (m *SqlDB) doQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if r := m.pickReplica(ctx, query); r != nil {
+		rows, err := m.queryOn(ctx, r.db, query, args...)
+		if !isConnError(err) {
+			return rows, err
+		}
+		r.markDown()
+	}
+	return m.queryOn(ctx, m.db, query, args...)
+}
+
+// 在指定的连接池上查询，开启缓存时使用预处理语句
+func (m *SqlDB) queryOn(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	if m.stmts == nil {
+		return m.executorOf(db).QueryContext(ctx, query, args...)
+	}
+	stmt, release, err := m.prepare(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.QueryContext(ctx, args...)
+}
+
+// 查询单行，开启缓存时使用预处理语句
+// 错误在Scan时才能获取，无法在从库连接失败时改查主库，因此只使用主库
+func (m *SqlDB) doQueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if m.stmts == nil {
+		return m.executor().QueryRowContext(ctx, query, args...)
+	}
+	stmt, release, err := m.prepare(ctx, m.db, query)
+	if err != nil {
+		// 预处理失败时退回到直接查询，由其返回错误
+		return m.executor().QueryRowContext(ctx, query, args...)
+	}
+	defer release()
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// 获取排序后的键，保证生成的SQL语句一致
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"go_lib/utils"
+	"testing"
+)
+
+// 测试预处理语句缓存
+func TestSqlDB_StmtCache(t *testing.T) {
+	db, fake := newFakeSqlDB("stmt_cache", MysqlDialect{})
+	db.SetStmtCache(2)
+
+	for i := 0; i < 3; i++ {
+		if err := db.Update(utils.M{"name": "a"}, utils.M{"id": i}, "t_user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := db.StmtCacheStats()
+	if stats.Size != 1 || stats.Hits != 2 || stats.Misses != 1 || fake.prepared != 1 {
+		t.Errorf("unexpected stats: %+v, prepared %d", stats, fake.prepared)
+	}
+
+	// 超出容量时淘汰最久未使用的语句
+	_ = db.Update(utils.M{"age": 1}, utils.M{"id": 1}, "t_user")
+	_ = db.Update(utils.M{"sex": 1}, utils.M{"id": 1}, "t_user")
+	stats = db.StmtCacheStats()
+	if stats.Size != 2 || stats.Evictions != 1 || stats.Capacity != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// 事务中使用缓存的语句
+	err := db.Transaction(func(tx *Tx) error {
+		return tx.Update(utils.M{"sex": 2}, utils.M{"id": 1}, "t_user")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := fake.log()
+	if log[len(log)-3] != "BEGIN" || log[len(log)-1] != "COMMIT" {
+		t.Errorf("unexpected log: %v", log)
+	}
+	if stats = db.StmtCacheStats(); stats.Hits != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	db.SetStmtCache(0)
+	if stats = db.StmtCacheStats(); stats.Size != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// 测试生成的SQL语句顺序固定
+func TestDBTable_Deterministic(t *testing.T) {
+	db, fake := newFakeSqlDB("deterministic", MysqlDialect{})
+	for i := 0; i < 5; i++ {
+		_, _ = db.Table("t_user").
+			Select(utils.M{"name": "", "id": "", "age": ""}).
+			Where(utils.M{"status": 1, "age[>]": 18, "name[like]": "a%"}, "").
+			Order(utils.M{"name": "ASC", "id": "DESC"}).
+			Query().Result()
+	}
+	log := fake.log()
+	for _, l := range log[1:] {
+		if l != log[0] {
+			t.Fatalf("sql changed: %s != %s", l, log[0])
+		}
+	}
+
+	_, _ = db.Table("t_user").OrderBy("name desc", "id").Query().Result()
+	if log = fake.log(); log[len(log)-1] != "SELECT * FROM `t_user`   ORDER BY `t_user`.`name` DESC,`t_user`.`id` ASC " {
+		t.Errorf("unexpected sql: %s", log[len(log)-1])
+	}
+	if _, err := db.Table("t_user").OrderBy("id; DROP").Query().Result(); err == nil {
+		t.Error("invalid order should be rejected")
+	}
+}
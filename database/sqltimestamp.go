@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"time"
+)
+
+// 自动维护的时间字段
+// 结构体也可以通过sql标签auto_create_time、auto_update_time指定字段，字段名与扫描结构体时的规则相同
+// 标签字段在新增、插入更新时按传入的结构体填充，更新时按UpdateVersioned传入的结构体或Model设置的模型填充
+// 传入结构体时auto_update_time字段总是设置为当前时间，其他字段只填充未设置的值
+type Timestamps struct {
+	CreatedAt string           // 新增时设置的字段，为空时不设置
+	UpdatedAt string           // 新增及更新时设置的字段，为空时不设置
+	Now       func() time.Time // 时钟，默认time.Now
+	Precision time.Duration    // 精度，默认为秒
+	Location  *time.Location   // 时区，默认使用时钟返回的时区
+}
+
+// 获取当前时间，按精度截断并转换时区
+func (ts *Timestamps) now() time.Time {
+	now := time.Now
+	if ts != nil && ts.Now != nil {
+		now = ts.Now
+	}
+	t := now()
+	precision := time.Second
+	if ts != nil && ts.Precision > 0 {
+		precision = ts.Precision
+	}
+	t = t.Truncate(precision)
+	if ts != nil && ts.Location != nil {
+		t = t.In(ts.Location)
+	}
+	return t
+}
+
+// 设置表自动维护的时间字段，Insert、InsertMany、Upsert及Update时填充调用方未设置的字段
+func (m *SqlDB) RegisterTimestamps(table string, ts Timestamps) {
+	if m.timestamps == nil {
+		m.timestamps = make(map[string]*Timestamps)
+	}
+	m.timestamps[table] = &ts
+}
+
+// 获取表的当前时间，用于时间字段及软删除
+func (m *SqlDB) now(table string) time.Time {
+	return m.timestamps[table].now()
+}
+
+// 结构体中标记为自动维护的时间字段，返回新增及更新时的字段名
+func timestampTags(data interface{}) (created []string, updated []string) {
+	return taggedColumns(data, "auto_create_time"), taggedColumns(data, "auto_update_time")
+}
+
+// 新增时填充时间字段，返回转换后的数据
+func (m *SqlDB) insertTimestamps(table string, orgData interface{}) (DM, error) {
+	data, err := ConvertData(orgData)
+	if err != nil {
+		return nil, err
+	}
+	ts := m.timestamps[table]
+	created, updated := timestampTags(orgData)
+	if ts != nil {
+		created = append(created, ts.CreatedAt)
+		updated = append(updated, ts.UpdatedAt)
+	}
+	if len(created) == 0 && len(updated) == 0 {
+		return data, nil
+	}
+	// 复制一份，不修改调用方的数据
+	filled := make(DM, len(data)+2)
+	for k, v := range data {
+		filled[k] = v
+	}
+	now := ts.now()
+	for _, c := range append(created, updated...) {
+		if c != "" && !timestampSet(filled, c) {
+			filled[c] = now
+		}
+	}
+	// 结构体可能是查询出的记录，更新时间需要刷新
+	for _, c := range taggedColumns(orgData, "auto_update_time") {
+		filled[c] = now
+	}
+	return filled, nil
+}
+
+// 更新时填充更新时间字段，model为结构体时同时填充标签指定的字段
+func (m *SqlDB) updateTimestamps(table string, data utils.M, model interface{}) utils.M {
+	columns := m.updatedColumns(table, model)
+	if len(columns) == 0 {
+		return data
+	}
+	// 字段可能带有运算符，如 updated_at[+]，零值时间视为未设置
+	set := make(map[string]bool, len(data))
+	for k, v := range data {
+		if timestampSet(DM{k: v}, k) {
+			set[m.explainColumn(k).Field] = true
+		}
+	}
+	filled := make(utils.M, len(data)+len(columns))
+	for k, v := range data {
+		filled[k] = v
+	}
+	now := m.now(table)
+	for _, c := range columns {
+		if !set[c] {
+			filled[c] = now
+		}
+	}
+	return filled
+}
+
+// 插入更新冲突时同时更新更新时间字段，data为结构体时包含标签指定的字段
+func (m *SqlDB) upsertTimestamps(table string, updateColumns []string, data interface{}) []string {
+	columns := m.updatedColumns(table, data)
+	if len(columns) == 0 || len(updateColumns) == 0 {
+		return updateColumns
+	}
+	set := make(map[string]bool, len(updateColumns))
+	for _, c := range updateColumns {
+		set[m.explainColumn(c).Field] = true
+	}
+	filled := append([]string{}, updateColumns...)
+	for _, c := range columns {
+		if !set[c] {
+			filled = append(filled, c)
+		}
+	}
+	return filled
+}
+
+// 更新时需要填充的时间字段，包括表设置的及结构体标签指定的字段
+func (m *SqlDB) updatedColumns(table string, model interface{}) []string {
+	_, columns := timestampTags(model)
+	if ts := m.timestamps[table]; ts != nil && ts.UpdatedAt != "" {
+		for _, c := range columns {
+			if c == ts.UpdatedAt {
+				return columns
+			}
+		}
+		columns = append(columns, ts.UpdatedAt)
+	}
+	return columns
+}
+
+// 调用方是否已设置时间字段，零值时间、nil、空指针及sql.NullTime等无效的可空值视为未设置
+func timestampSet(data DM, column string) bool {
+	v, ok := data[column]
+	if !ok {
+		return false
+	}
+	return !isUnsetTime(v)
+}
+
+// 是否为未设置的时间值
+func isUnsetTime(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.IsZero()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return true
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		return err == nil && (value == nil || isUnsetTime(value))
+	}
+	if rv.Kind() == reflect.Ptr {
+		return isUnsetTime(rv.Elem().Interface())
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 测试自动填充时间字段
+func TestSqlDB_Timestamps(t *testing.T) {
+	db, fake := newFakeSqlDB("timestamps", MysqlDialect{})
+	now := time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	loc := time.FixedZone("CST", 8*3600)
+	db.RegisterTimestamps("t_user", Timestamps{
+		CreatedAt: "created_at",
+		UpdatedAt: "updated_at",
+		Now:       func() time.Time { return now },
+		Location:  loc,
+	})
+	expect := now.Truncate(time.Second).In(loc)
+
+	data := utils.M{"name": "a"}
+	if _, ok := db.Insert("t_user", data); !ok {
+		t.Fatal(db.GetLastError())
+	}
+	if !reflect.DeepEqual(fake.args[0], []driver.Value{expect, "a", expect}) {
+		t.Errorf("unexpected args: %v", fake.args[0])
+	}
+	if log := fake.log(); log[0] != "INSERT INTO `t_user`(`created_at`,`name`,`updated_at`) VALUES(?,?,?)" {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if len(data) != 1 {
+		t.Error("caller data should not be modified")
+	}
+
+	// 不覆盖调用方设置的值
+	created := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Table("t_user").Insert(utils.M{"name": "b", "created_at": created})
+	if fake.args[1][0] != created || fake.args[1][2] != expect {
+		t.Errorf("unexpected args: %v", fake.args[1])
+	}
+
+	// 更新只设置更新时间
+	db.Table("t_user").Where(utils.M{"id": 1}, "").Update(utils.M{"name": "c"})
+	if log := fake.log(); log[2] != "UPDATE `t_user` SET `name` = ?,`updated_at` = ? WHERE (`t_user`.`id` = ?)" || fake.args[2][1] != expect {
+		t.Errorf("unexpected update: %s %v", log[2], fake.args[2])
+	}
+	_ = db.Update(utils.M{"updated_at": created}, utils.M{"id": 1}, "t_user")
+	if fake.args[3][0] != created {
+		t.Errorf("unexpected args: %v", fake.args[3])
+	}
+
+	// 批量新增
+	_, err := db.InsertMany("t_user", []utils.M{{"name": "d"}, {"name": "e", "created_at": created}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := fake.args[4]; len(args) != 6 || args[0] != expect || args[3] != created {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	// 插入更新冲突时同时更新更新时间
+	_, _ = db.Upsert("t_user", utils.M{"id": 1, "name": "f"}, []string{"name"})
+	if log := fake.log(); log[5] != "INSERT INTO `t_user`(`created_at`,`id`,`name`,`updated_at`) VALUES(?,?,?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`),`updated_at` = VALUES(`updated_at`)" {
+		t.Errorf("unexpected sql: %s", log[5])
+	}
+}
+
+type timestampUser struct {
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at" sql:"auto_create_time"`
+	UpdatedAt *time.Time `json:"updated_at" sql:"auto_update_time"`
+}
+
+// 测试通过结构体标签填充时间字段
+func TestSqlDB_TimestampTags(t *testing.T) {
+	db, fake := newFakeSqlDB("timestamp_tags", MysqlDialect{})
+	db.Insert("t_user", &timestampUser{Name: "a"})
+	args := fake.args[0]
+	created, ok1 := args[0].(time.Time)
+	updated, ok2 := args[2].(time.Time)
+	if !ok1 || !ok2 || created.IsZero() || created.Nanosecond() != 0 || !created.Equal(updated) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+type taggedTimeUser struct {
+	Id       int64     `db:"id"`
+	Name     string    `json:"name,omitempty"`
+	Version  int64     `db:"version"`
+	Created  time.Time `db:"create_time" sql:"auto_create_time"`
+	Modified time.Time `db:"modify_time" sql:"auto_update_time"`
+}
+
+// 测试标签字段按db标签命名，并在更新及插入更新时填充
+func TestSqlDB_TimestampTagsUpdate(t *testing.T) {
+	db, fake := newFakeSqlDB("timestamp_tags_update", MysqlDialect{})
+	u := &taggedTimeUser{Id: 1, Name: "a"}
+
+	db.Insert("t_user", u)
+	if log := fake.log(); log[0] != "INSERT INTO `t_user`(`create_time`,`id`,`modify_time`,`name`,`version`) VALUES(?,?,?,?,?)" {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if args := fake.args[0]; args[0].(time.Time).IsZero() || args[2].(time.Time).IsZero() {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	// 通过Model设置的模型填充更新时间
+	db.Table("t_user").Model(u).Where(utils.M{"id": 1}, "").Update(utils.M{"name": "b"})
+	if log := fake.log(); log[1] != "UPDATE `t_user` SET `modify_time` = ?,`name` = ? WHERE (`t_user`.`id` = ?)" {
+		t.Errorf("unexpected sql: %s", log[1])
+	}
+
+	// 结构体中零值的更新时间被填充
+	_ = db.Table("t_user").Where(utils.M{"id": 1}, "").UpdateVersioned(u, 0)
+	if args := fake.args[2]; args[0].(time.Time).IsZero() {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	// 插入更新冲突时同时更新标签字段
+	_, _ = db.Upsert("t_user", u, []string{"name"})
+	if log := fake.log(); log[3] != "INSERT INTO `t_user`(`create_time`,`id`,`modify_time`,`name`,`version`) VALUES(?,?,?,?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`),`modify_time` = VALUES(`modify_time`)" {
+		t.Errorf("unexpected sql: %s", log[3])
+	}
+}
+
+// 测试查询出的结构体更新时刷新更新时间
+func TestSqlDB_TimestampTagsRefresh(t *testing.T) {
+	db, fake := newFakeSqlDB("timestamp_tags_refresh", MysqlDialect{})
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	u := &taggedTimeUser{Id: 1, Name: "a", Created: old, Modified: old}
+
+	_ = db.Table("t_user").Where(utils.M{"id": 1}, "").UpdateVersioned(u, 0)
+	_, _ = db.Upsert("t_user", u, []string{"name"})
+	_, _ = db.Insert("t_user", u)
+	for i, args := range fake.args {
+		modified := false
+		for _, v := range args {
+			if tm, ok := v.(time.Time); ok && tm.After(old) {
+				modified = true
+			}
+		}
+		if !modified {
+			t.Errorf("statement %d: update time is not refreshed: %v", i, args)
+		}
+	}
+	// 创建时间保留结构体中的值
+	if args := fake.args[2]; args[0] != old {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+// 与sql.NullTime相同的可空时间
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n nullTime) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Time, nil
+}
+
+// 测试未设置的时间值
+func TestTimestampSet(t *testing.T) {
+	now := time.Now()
+	var nilTime *time.Time
+	var nilNull *nullTime
+	tests := []struct {
+		value interface{}
+		set   bool
+	}{
+		{nil, false},
+		{time.Time{}, false},
+		{now, true},
+		{nilTime, false},
+		{&time.Time{}, false},
+		{&now, true},
+		{nullTime{}, false},
+		{nullTime{Time: now, Valid: true}, true},
+		{nilNull, false},
+		{&nullTime{Time: now, Valid: true}, true},
+		{"2020-01-01", true},
+	}
+	for i, test := range tests {
+		if set := timestampSet(DM{"updated_at": test.value}, "updated_at"); set != test.set {
+			t.Errorf("%d: %v expect %v", i, test.value, test.set)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 在事务绑定的SqlDB上再次开启事务，嵌套事务需要使用Tx.BeginTrans
+var ErrNestedTrans = errors.New("database: transaction already started, use Tx.BeginTrans for nested transactions")
+
+// 事务
+// 内嵌一个绑定了该事务的SqlDB，因此拥有SqlDB和DBTable的全部操作，且不会影响其他协程
+type Tx struct {
+	*SqlDB
+	ctx       context.Context
+	savepoint string // 嵌套事务的保存点名称
+	depth     int    // 嵌套层级
+	done      bool
+}
+
+// 开启事务
+func (m *SqlDB) BeginTrans() (*Tx, error) {
+	return m.BeginTransContext(context.Background(), nil)
+}
+
+// 开启事务，支持上下文及事务选项
+func (m *SqlDB) BeginTransContext(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if m.tx != nil {
+		m.setLastError(ErrNestedTrans)
+		return nil, ErrNestedTrans
+	}
+	tx, err := m.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, m.handleError(ctx, err)
+	}
+	db := m.clone()
+	db.tx = tx
+	return &Tx{SqlDB: db, ctx: ctx}, nil
+}
+
+// 在事务中执行fn，fn返回错误或panic时回滚，否则提交
+func (m *SqlDB) Transaction(fn func(tx *Tx) error) error {
+	return m.TransactionContext(context.Background(), fn)
+}
+
+// 在事务中执行fn，支持上下文
+func (m *SqlDB) TransactionContext(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := m.BeginTransContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return tx.run(fn)
+}
+
+// 开启嵌套事务，使用SAVEPOINT实现
+func (t *Tx) BeginTrans() (*Tx, error) {
+	if t.done {
+		return nil, sql.ErrTxDone
+	}
+	savepoint := fmt.Sprintf("sp_%d", t.depth+1)
+	if _, err := t.ExecContext(t.ctx, "SAVEPOINT "+savepoint); err != nil {
+		return nil, err
+	}
+	return &Tx{SqlDB: t.SqlDB, ctx: t.ctx, savepoint: savepoint, depth: t.depth + 1}, nil
+}
+
+// 在嵌套事务中执行fn
+func (t *Tx) Transaction(fn func(tx *Tx) error) error {
+	tx, err := t.BeginTrans()
+	if err != nil {
+		return err
+	}
+	return tx.run(fn)
+}
+
+// 提交事务，嵌套事务则释放保存点
+func (t *Tx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	if t.savepoint != "" {
+		_, err := t.ExecContext(t.ctx, "RELEASE SAVEPOINT "+t.savepoint)
+		return err
+	}
+	return t.tx.Commit()
+}
+
+// 回滚事务，嵌套事务则回滚到保存点
+func (t *Tx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	if t.savepoint != "" {
+		_, err := t.ExecContext(t.ctx, "ROLLBACK TO SAVEPOINT "+t.savepoint)
+		return err
+	}
+	return t.tx.Rollback()
+}
+
+// 关闭事务，未提交时回滚，不会关闭数据库连接池
+func (t *Tx) Close() {
+	if !t.done {
+		_ = t.Rollback()
+	}
+}
+
+// 执行事务方法
+func (t *Tx) run(fn func(tx *Tx) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			_ = t.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(t); err != nil {
+		_ = t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
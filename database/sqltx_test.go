@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+)
+
+// 测试事务提交与回滚
+func TestSqlDB_Transaction(t *testing.T) {
+	db, fake := newFakeSqlDB("transaction", MysqlDialect{})
+
+	err := db.Transaction(func(tx *Tx) error {
+		if db.tx != nil {
+			t.Error("transaction leaked into shared SqlDB")
+		}
+		tx.Table("t_user").Where(utils.M{"id": 1}, "").Update(utils.M{"age": 1})
+		// 嵌套事务失败只回滚到保存点
+		_ = tx.Transaction(func(tx *Tx) error {
+			return errors.New("inner failed")
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"BEGIN",
+		"UPDATE `t_user` SET `age` = ? WHERE (`t_user`.`id` = ?)",
+		"SAVEPOINT sp_1",
+		"ROLLBACK TO SAVEPOINT sp_1",
+		"COMMIT",
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, expect) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+}
+
+// 测试panic时回滚
+func TestSqlDB_TransactionPanic(t *testing.T) {
+	db, fake := newFakeSqlDB("transaction_panic", MysqlDialect{})
+	defer func() {
+		if recover() == nil {
+			t.Error("panic should be re-raised")
+		}
+		log := fake.log()
+		if log[len(log)-1] != "ROLLBACK" {
+			t.Errorf("expect rollback, got %q", log)
+		}
+	}()
+	_ = db.Transaction(func(tx *Tx) error {
+		panic("boom")
+	})
+}
+
+// 测试事务中再次开启事务时返回错误，关闭事务时只回滚
+func TestSqlDB_TransactionNested(t *testing.T) {
+	db, fake := newFakeSqlDB("transaction_nested", MysqlDialect{})
+
+	tx, err := db.BeginTrans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.SqlDB.BeginTrans(); err != ErrNestedTrans {
+		t.Errorf("expect nested transaction error, got %v", err)
+	}
+	if _, err = tx.Table("t_user").BeginTrans(); err != ErrNestedTrans {
+		t.Errorf("expect nested transaction error, got %v", err)
+	}
+	tx.Close()
+	tx.Close()
+	if err = tx.Commit(); err != sql.ErrTxDone {
+		t.Errorf("expect tx done, got %v", err)
+	}
+	if log := fake.log(); !reflect.DeepEqual(log, []string{"BEGIN", "ROLLBACK"}) {
+		t.Errorf("unexpected sql: %q", log)
+	}
+	// 连接池未被关闭
+	if _, err = db.Exec("UPDATE t_user SET age = 1"); err != nil {
+		t.Error(err)
+	}
+}
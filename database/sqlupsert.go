@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// 插入更新的执行结果
+type UpsertState int
+
+const (
+	UpsertNone     UpsertState = iota // 冲突被忽略或数据没有变化
+	UpsertInserted                    // 新增了记录
+	UpsertUpdated                     // 更新了已存在的记录
+	UpsertAffected                    // 新增或更新了记录，数据库无法区分
+)
+
+// 插入更新
+// updateColumns为冲突时需要更新的字段，name[+]、name[-]表示在原值上加减新数据的值，为空时忽略冲突
+// conflictKeys为判断冲突的唯一键，为空时使用主键
+func (m *SqlDB) Upsert(table string, data interface{}, updateColumns []string, conflictKeys ...string) (UpsertState, error) {
+	return m.UpsertContext(context.Background(), table, data, updateColumns, conflictKeys...)
+}
+
+// 插入更新，支持上下文
+func (m *SqlDB) UpsertContext(ctx context.Context, table string, data interface{}, updateColumns []string, conflictKeys ...string) (UpsertState, error) {
+	if len(conflictKeys) == 0 && m.primaryKey(table) != "" {
+		conflictKeys = []string{m.primaryKey(table)}
+	}
+	if err := m.beforeInsert(ctx, data); err != nil {
+		m.setLastError(err)
+		return UpsertNone, err
+	}
+	dm, err := m.insertTimestamps(table, data)
+	if err != nil {
+		return UpsertNone, err
+	}
+	state, err := m.upsert(ctx, table, dm, m.upsertTimestamps(table, updateColumns, data), conflictKeys)
+	if err == nil {
+		if err = m.afterInsert(ctx, data); err != nil {
+			m.setLastError(err)
+		}
+	}
+	return state, err
+}
+
+// 插入更新
+func (m *SqlDB) upsert(ctx context.Context, table string, orgData interface{}, updateColumns []string, conflictKeys []string) (UpsertState, error) {
+	data, err := ConvertData(orgData)
+	if err != nil {
+		return UpsertNone, err
+	}
+	dialect := m.Dialect()
+
+	columns := sortedKeys(data)
+	values := make([]interface{}, 0, len(columns))
+	valMask := make([]string, 0, len(columns))
+	for _, c := range columns {
+		mask, args, err := m.valueSql(data[c])
+		if err != nil {
+			return UpsertNone, err
+		}
+		values = append(values, args...)
+		valMask = append(valMask, mask)
+	}
+
+	var sets []string
+	for _, column := range updateColumns {
+		field := m.explainColumn(column)
+		name := m.FormatColumn(field.Field)
+		switch field.Icon {
+		case "=":
+			sets = append(sets, fmt.Sprintf("%s = %s", name, dialect.Excluded(field.Field)))
+		case "+", "-":
+			// views[+] => views = table.views + 新数据的views
+			sets = append(sets, fmt.Sprintf("%s = %s.%s %s %s", name, m.FormatColumn(table), name, field.Icon, dialect.Excluded(field.Field)))
+		default:
+			return UpsertNone, fmt.Errorf("database: unsupported upsert column %s", column)
+		}
+	}
+
+	sqlStr := fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) %s",
+		m.FormatColumn(table),
+		strings.Join(quoteColumns(dialect, columns), ","),
+		strings.Join(valMask, ","),
+		dialect.OnConflict(conflictKeys, sets),
+	)
+
+	// 通过RETURNING判断是否为新增
+	if returning := dialect.UpsertReturning(); returning != "" {
+		var inserted bool
+		err = m.scanRow(ctx, sqlStr+" "+returning, values, &inserted)
+		if err == sql.ErrNoRows {
+			return UpsertNone, nil
+		}
+		if err != nil {
+			return UpsertNone, err
+		}
+		if inserted {
+			return UpsertInserted, nil
+		}
+		return UpsertUpdated, nil
+	}
+
+	res, err := m.ExecContext(ctx, sqlStr, values...)
+	if err != nil {
+		return UpsertNone, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return UpsertNone, err
+	}
+	return dialect.UpsertState(affected), nil
+}
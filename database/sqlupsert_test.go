@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"testing"
+)
+
+// 测试插入更新语句
+func TestDBTable_Upsert(t *testing.T) {
+	db, fake := newFakeSqlDB("upsert", MysqlDialect{})
+	data := utils.M{"id": 1, "name": "张三", "views": 1}
+
+	fake.changed = 2
+	state, err := db.Table("t_user").Upsert(data, []string{"name", "views[+]"})
+	if err != nil || state != UpsertUpdated {
+		t.Errorf("unexpected state: %v %v", state, err)
+	}
+	expect := "INSERT INTO `t_user`(`id`,`name`,`views`) VALUES(?,?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`),`views` = `t_user`.`views` + VALUES(`views`)"
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+
+	// 忽略冲突
+	fake.changed = 0
+	state, err = db.Table("t_user").Upsert(data, nil, "name")
+	if err != nil || state != UpsertNone {
+		t.Errorf("unexpected state: %v %v", state, err)
+	}
+	if log := fake.log(); log[1] != "INSERT INTO `t_user`(`id`,`name`,`views`) VALUES(?,?,?) ON DUPLICATE KEY UPDATE `name` = `name`" {
+		t.Errorf("unexpected sql: %s", log[1])
+	}
+
+	if _, err = db.Table("t_user").Upsert(data, []string{"views[>]"}); err == nil {
+		t.Error("unsupported operator should return error")
+	}
+
+	// PostgreSQL
+	pg, fake := newFakeSqlDB("upsert_pg", PostgresDialect{})
+	fake.setRows([]string{"inserted"}, []driver.Value{true})
+	state, err = pg.Table("t_user").Upsert(data, []string{"views[+]"})
+	if err != nil || state != UpsertInserted {
+		t.Errorf("unexpected state: %v %v", state, err)
+	}
+	expect = `INSERT INTO "t_user"("id","name","views") VALUES($1,$2,$3) ON CONFLICT ("id") DO UPDATE SET "views" = "t_user"."views" + EXCLUDED."views" RETURNING (xmax = 0)`
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+}
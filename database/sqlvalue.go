@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 高精度数值，保存数据库返回的原始文本，避免转换为浮点数丢失精度
+type Decimal string
+
+// 转换成字符串
+func (d Decimal) String() string {
+	return string(d)
+}
+
+// 转换成浮点数
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(string(d), 64)
+}
+
+// 转换成json时输出数字
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	return []byte(d), nil
+}
+
+// 日期时间格式
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// 字段值解码器
+type valueDecoder func(b []byte) interface{}
+
+// 根据字段类型获取解码器
+func columnDecoder(ct *sql.ColumnType) valueDecoder {
+	typeName := strings.ToUpper(ct.DatabaseTypeName())
+	typeName = strings.TrimPrefix(typeName, "UNSIGNED ")
+	switch typeName {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "INT2", "INT4", "INT8", "YEAR", "SERIAL":
+		return decodeInt
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8", "DOUBLE PRECISION":
+		return decodeFloat
+	case "DECIMAL", "NUMERIC":
+		return decodeDecimal
+	case "DATE", "DATETIME", "TIMESTAMP", "TIMESTAMPTZ":
+		return decodeTime
+	case "BOOL", "BOOLEAN":
+		return decodeBool
+	case "BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BYTEA", "BIT", "GEOMETRY":
+		return decodeBytes
+	default:
+		return decodeString
+	}
+}
+
+func decodeString(b []byte) interface{} {
+	return string(b)
+}
+
+func decodeBytes(b []byte) interface{} {
+	return append([]byte{}, b...)
+}
+
+func decodeInt(b []byte) interface{} {
+	if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		return i
+	}
+	// 超出int64范围的无符号整数
+	if u, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+		return u
+	}
+	return string(b)
+}
+
+func decodeFloat(b []byte) interface{} {
+	if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+		return f
+	}
+	return string(b)
+}
+
+func decodeDecimal(b []byte) interface{} {
+	return Decimal(b)
+}
+
+func decodeBool(b []byte) interface{} {
+	switch string(b) {
+	case "1", "t", "true", "TRUE", "y", "yes":
+		return true
+	case "0", "f", "false", "FALSE", "n", "no":
+		return false
+	}
+	return string(b)
+}
+
+func decodeTime(b []byte) interface{} {
+	str := string(b)
+	// MySQL的零值日期
+	if strings.HasPrefix(str, "0000-00-00") {
+		return time.Time{}
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t
+		}
+	}
+	return str
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"go_lib/utils"
+	"reflect"
+	"sync/atomic"
+)
+
+// 乐观锁更新时版本号不一致，记录已被修改或不存在
+var ErrStaleVersion = errors.New("database: stale version")
+
+// 按版本号更新，用于乐观锁，查询后清除查询条件
+// 版本字段默认为version，data为结构体时可以通过sql标签version指定，版本字段不会按data的值更新
+// 更新时版本号加1，并添加版本号等于expectedVersion的条件，没有记录被更新时返回ErrStaleVersion
+// 未通过Model设置模型时，data实现的更新钩子在更新前后调用
+// data为结构体时不更新主键及创建时间字段，标签为auto_update_time的字段总是设置为当前时间
+func (t *DBTable) UpdateVersioned(data interface{}, expectedVersion int64) error {
+	defer t.Clear()
+	model := t.model
+	if model == nil {
+		model = data
+	}
+	if err := t.db.beforeUpdate(t.context(), model); err != nil {
+		t.db.setLastError(err)
+		return err
+	}
+	column := versionColumn(data)
+	dm, err := ConvertData(data)
+	if err != nil {
+		t.err = err
+	}
+	skip := t.versionSkipColumns(data, column)
+	set := make(utils.M, len(dm)+1)
+	for k, v := range dm {
+		if !skip[t.db.explainColumn(k).Field] {
+			set[k] = v
+		}
+	}
+	set[column+"[+]"] = 1
+	if updated := taggedColumns(data, "auto_update_time"); len(updated) > 0 {
+		now := t.db.now(t.table)
+		for _, c := range updated {
+			set[c] = now
+		}
+	}
+	t.Where(utils.M{column: expectedVersion}, "")
+	t.applySoftDelete()
+	if err = t.checkError(); err != nil {
+		return err
+	}
+
+	set = t.db.updateTimestamps(t.table, set, model)
+	var affected int64
+	ok := t.eachShard(func(db *SqlDB, table string) error {
+		n, err := db.update(t.context(), set, t.where, table)
+		atomic.AddInt64(&affected, n)
+		return err
+	})
+	if !ok {
+		return t.db.GetLastError()
+	}
+	if affected == 0 {
+		t.db.setLastError(ErrStaleVersion)
+		return ErrStaleVersion
+	}
+	if err = t.db.afterUpdate(t.context(), model); err != nil {
+		t.db.setLastError(err)
+		return err
+	}
+	return nil
+}
+
+// 按版本号更新时不从data中更新的字段，data为结构体时包括主键及创建时间字段
+func (t *DBTable) versionSkipColumns(data interface{}, column string) map[string]bool {
+	skip := map[string]bool{column: true}
+	if reflect.Indirect(reflect.ValueOf(data)).Kind() != reflect.Struct {
+		return skip
+	}
+	skip[t.pk] = true
+	for _, c := range taggedColumns(data, "auto_create_time") {
+		skip[c] = true
+	}
+	if ts := t.db.timestamps[t.table]; ts != nil && ts.CreatedAt != "" {
+		skip[ts.CreatedAt] = true
+	}
+	return skip
+}
+
+// 获取结构体中标记为版本号的字段，字段名与扫描结构体时的规则相同，没有时返回version
+func versionColumn(data interface{}) string {
+	if columns := taggedColumns(data, "version"); len(columns) > 0 {
+		return columns[0]
+	}
+	return "version"
+}
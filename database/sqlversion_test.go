@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql/driver"
+	"go_lib/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type versionedGoods struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	Revision int64  `json:"revision" sql:"version"`
+}
+
+type versionedPost struct {
+	Id       int64  `db:"id"`
+	Title    string `json:"title,omitempty"`
+	Revision int64  `db:"rev" sql:"version"`
+}
+
+// 测试乐观锁更新
+func TestDBTable_UpdateVersioned(t *testing.T) {
+	db, fake := newFakeSqlDB("versioned", MysqlDialect{})
+
+	err := db.Table("t_user").Where(utils.M{"id": 1}, "").UpdateVersioned(utils.M{"name": "a", "version": 9}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "UPDATE `t_user` SET `name` = ?,`version` = `version` + ? WHERE (`t_user`.`id` = ?) AND (`t_user`.`version` = ?)"
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if !reflect.DeepEqual(fake.args[0], []driver.Value{"a", int64(1), int64(1), int64(3)}) {
+		t.Errorf("unexpected args: %v", fake.args[0])
+	}
+
+	// 没有记录被更新
+	fake.changed = 0
+	err = db.Table("t_user").Where(utils.M{"id": 1}, "").UpdateVersioned(utils.M{"name": "b"}, 3)
+	if err != ErrStaleVersion || db.GetLastError() != ErrStaleVersion {
+		t.Errorf("expect stale version, got %v", err)
+	}
+
+	// 通过结构体标签指定版本字段
+	fake.changed = 1
+	err = db.Table("t_goods").Where(utils.M{"id": 1}, "").UpdateVersioned(&versionedGoods{Id: 1, Name: "c", Revision: 5}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 结构体的主键不更新
+	expect = "UPDATE `t_goods` SET `name` = ?,`revision` = `revision` + ? WHERE (`t_goods`.`id` = ?) AND (`t_goods`.`revision` = ?)"
+	if log := fake.log(); log[2] != expect {
+		t.Errorf("unexpected sql: %s", log[2])
+	}
+}
+
+// 测试通过db标签命名的版本字段
+func TestDBTable_UpdateVersionedDBTag(t *testing.T) {
+	db, fake := newFakeSqlDB("versioned_db_tag", MysqlDialect{})
+
+	err := db.Table("t_post").Where(utils.M{"id": 1}, "").UpdateVersioned(&versionedPost{Id: 1, Title: "a", Revision: 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "UPDATE `t_post` SET `rev` = `rev` + ?,`title` = ? WHERE (`t_post`.`id` = ?) AND (`t_post`.`rev` = ?)"
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+	if !reflect.DeepEqual(fake.args[0], []driver.Value{int64(1), "a", int64(1), int64(2)}) {
+		t.Errorf("unexpected args: %v", fake.args[0])
+	}
+}
+
+// 测试按结构体更新时不更新主键及创建时间
+func TestDBTable_UpdateVersionedSkip(t *testing.T) {
+	db, fake := newFakeSqlDB("versioned_skip", MysqlDialect{})
+	db.RegisterPrimaryKey("t_user", "uid")
+	db.RegisterTimestamps("t_user", Timestamps{CreatedAt: "created_at"})
+
+	type user struct {
+		Uid       int64     `db:"uid"`
+		Name      string    `db:"name"`
+		CreatedAt time.Time `db:"created_at"`
+		Created   time.Time `db:"create_time" sql:"auto_create_time"`
+		Version   int64     `db:"version"`
+	}
+	err := db.Table("t_user").Where(utils.M{"uid": 1}, "").UpdateVersioned(&user{Uid: 1, Name: "a", Version: 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "UPDATE `t_user` SET `name` = ?,`version` = `version` + ? WHERE (`t_user`.`uid` = ?) AND (`t_user`.`version` = ?)"
+	if log := fake.log(); log[0] != expect {
+		t.Errorf("unexpected sql: %s", log[0])
+	}
+}
@@ -0,0 +1,316 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_lib/utils"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 字段验证规则，字段名后方括号中的内容为操作符，如 age[>=]、name[!like]、id[count][>]
+var columnReg = regexp.MustCompile(`^(.+?)\[([^\[\]]+)]$`)
+
+// JSON路径验证规则，如 color、size.width、tags[0]
+var jsonPathReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*|\[\d+])*$`)
+
+// 条件中支持的操作符
+var whereOperators = map[string]string{
+	"=":       "=",
+	"!":       "!=",
+	">":       ">",
+	"<":       "<",
+	">=":      ">=",
+	"<=":      "<=",
+	"like":    "LIKE",
+	"!like":   "NOT LIKE",
+	"<>":      "BETWEEN",
+	"!<>":     "NOT BETWEEN",
+	"regexp":  "REGEXP",
+	"!regexp": "NOT REGEXP",
+	"json":    "JSON",
+}
+
+// 更新时支持的操作符
+var updateOperators = map[string]bool{"=": true, "+": true, "-": true}
+
+// 条件中支持的聚合函数
+var aggregateFuncs = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MAX": true, "MIN": true}
+
+// 处理where条件
+// 条件不合法时返回恒为假的条件，错误可通过GetLastError获取
+func (m *SqlDB) ProcessWhere(where utils.M, icon string, table string) (string, []interface{}) {
+	whereStr, values, err := m.buildWhere(where, icon, table)
+	if err != nil {
+		m.setLastError(err)
+		return "(1 = 0)", nil
+	}
+	return whereStr, values
+}
+
+// 生成where条件
+func (m *SqlDB) buildWhere(where utils.M, icon string, table string) (string, []interface{}, error) {
+	var whereStrings []string
+	var values []interface{}
+	for _, i := range sortedKeys(where) {
+		v := where[i]
+		var tmpWhere string
+		var val []interface{}
+		var err error
+		switch {
+		case i == "AND" || i == "OR":
+			// 递归获取所有查询条件
+			sub, ok := v.(utils.M)
+			if !ok {
+				return "", nil, fmt.Errorf("database: %s condition must be utils.M", i)
+			}
+			tmpWhere, val, err = m.buildWhere(sub, i, table)
+		case i == "EXISTS" || i == "NOT EXISTS":
+			// 子查询是否存在记录
+			tmpWhere, val, err = m.existsWhere(i, v)
+		default:
+			tmpWhere, val, err = m.formatWhere(i, table, v)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		whereStrings = append(whereStrings, tmpWhere)
+		values = append(values, val...)
+	}
+	wherePrefix := fmt.Sprintf(" %s ", icon)
+	whereStr := fmt.Sprintf("(%s)", strings.Join(whereStrings, wherePrefix))
+	return whereStr, values, nil
+}
+
+// 生成多次调用Where的条件，各组条件之间使用AND连接
+func (m *SqlDB) buildWheres(wheres []utils.M, table string) (string, []interface{}, error) {
+	if len(wheres) == 0 {
+		return m.buildWhere(nil, "AND", table)
+	}
+	var whereStrings []string
+	var values []interface{}
+	for _, where := range wheres {
+		whereStr, val, err := m.buildWhere(where, "AND", table)
+		if err != nil {
+			return "", nil, err
+		}
+		whereStrings = append(whereStrings, whereStr)
+		values = append(values, val...)
+	}
+	return strings.Join(whereStrings, " AND "), values, nil
+}
+
+// 处理EXISTS条件，值可以是一个或多个子查询
+func (m *SqlDB) existsWhere(icon string, v interface{}) (string, []interface{}, error) {
+	var subs []*DBTable
+	switch val := v.(type) {
+	case *DBTable:
+		subs = append(subs, val)
+	case []*DBTable:
+		subs = val
+	default:
+		return "", nil, fmt.Errorf("database: %s condition must be *DBTable", icon)
+	}
+	var tmp []string
+	var values []interface{}
+	for _, sub := range subs {
+		subSql, val, err := sub.subQuery()
+		if err != nil {
+			return "", nil, err
+		}
+		tmp = append(tmp, fmt.Sprintf("%s (%s)", icon, subSql))
+		values = append(values, val...)
+	}
+	return strings.Join(tmp, " AND "), values, nil
+}
+
+// 格式化单个where条件
+func (m *SqlDB) formatWhere(column string, table string, v interface{}) (string, []interface{}, error) {
+	filed := m.explainColumn(column)
+	whereIcon, ok := whereOperators[filed.Icon]
+	if !ok {
+		return "", nil, fmt.Errorf("database: unknown operator [%s] in %s", filed.Icon, column)
+	}
+	columnStr, err := m.whereColumn(filed.Field, table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 子查询
+	if sub, ok := v.(*DBTable); ok {
+		return m.formatSubWhere(column, filed.Icon, columnStr, sub)
+	}
+
+	// 表达式或字段引用，如 qty[>] => Col("min_qty")
+	if e, ok := v.(*Expr); ok {
+		switch filed.Icon {
+		case "<>", "!<>", "json":
+			return "", nil, fmt.Errorf("database: operator [%s] does not accept an expression in %s", filed.Icon, column)
+		case "regexp", "!regexp":
+			whereIcon = m.Dialect().Regexp(filed.Icon == "!regexp")
+		}
+		exprStr, args, err := m.exprSql(e)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s %s %s", columnStr, whereIcon, exprStr), args, nil
+	}
+
+	// 空值
+	if v == nil {
+		switch filed.Icon {
+		case "=":
+			return columnStr + " IS NULL", nil, nil
+		case "!":
+			return columnStr + " IS NOT NULL", nil, nil
+		}
+		return "", nil, fmt.Errorf("database: operator [%s] does not accept nil in %s", filed.Icon, column)
+	}
+
+	switch filed.Icon {
+	case "<>", "!<>":
+		list, ok := sliceValues(v)
+		if !ok || len(list) != 2 {
+			return "", nil, fmt.Errorf("database: %s requires a two-element slice", column)
+		}
+		return fmt.Sprintf("%s %s ? AND ?", columnStr, whereIcon), list, nil
+	case "regexp", "!regexp":
+		op := m.Dialect().Regexp(filed.Icon == "!regexp")
+		return fmt.Sprintf("%s %s ?", columnStr, op), []interface{}{v}, nil
+	case "json":
+		val, err := jsonValue(v)
+		if err != nil {
+			return "", nil, err
+		}
+		return m.Dialect().JSONContains(columnStr), []interface{}{val}, nil
+	}
+
+	// 处理多个值的情况
+	if list, ok := sliceValues(v); ok {
+		switch filed.Icon {
+		case "=", "!":
+		default:
+			return "", nil, fmt.Errorf("database: operator [%s] does not accept a slice in %s", filed.Icon, column)
+		}
+		// IN ()不是合法的语句
+		if len(list) == 0 {
+			if filed.Icon == "!" {
+				return "(1 = 1)", nil, nil
+			}
+			return "(1 = 0)", nil, nil
+		}
+		whereIcon = "IN"
+		if filed.Icon == "!" {
+			whereIcon = "NOT IN"
+		}
+		maskArgs := strings.TrimRight(strings.Repeat("?,", len(list)), ",")
+		return fmt.Sprintf("%s %s (%s)", columnStr, whereIcon, maskArgs), list, nil
+	}
+	return fmt.Sprintf("%s %s ?", columnStr, whereIcon), []interface{}{v}, nil
+}
+
+// 格式化子查询条件，未指定比较符时使用IN
+// id => id IN (SELECT ...)，id[!] => id NOT IN (SELECT ...)，id[=] => id = (SELECT ...)
+func (m *SqlDB) formatSubWhere(column string, icon string, columnStr string, sub *DBTable) (string, []interface{}, error) {
+	subSql, values, err := sub.subQuery()
+	if err != nil {
+		return "", nil, err
+	}
+	var whereIcon string
+	switch icon {
+	case "=":
+		whereIcon = "IN"
+		if strings.HasSuffix(column, "[=]") {
+			whereIcon = "="
+		}
+	case "!":
+		whereIcon = "NOT IN"
+	case ">", "<", ">=", "<=":
+		whereIcon = icon
+	default:
+		return "", nil, fmt.Errorf("database: operator [%s] does not accept a subquery in %s", icon, column)
+	}
+	return fmt.Sprintf("%s %s (%s)", columnStr, whereIcon, subSql), values, nil
+}
+
+// 格式化条件中的字段
+// 支持聚合函数写法，如id[count] => COUNT(table.id)
+// 支持JSON路径写法，如attrs->>color => attrs中color的值
+// table为空时不添加表名，用于HAVING中引用别名
+func (m *SqlDB) whereColumn(column string, table string) (string, error) {
+	var funcStr, path string
+	if i := strings.Index(column, "->>"); i != -1 {
+		column, path = column[:i], column[i+3:]
+		if !jsonPathReg.MatchString(path) {
+			return "", fmt.Errorf("database: invalid json path %s", path)
+		}
+	} else if match := fieldReg.FindStringSubmatch(column); len(match) > 0 {
+		column = match[1]
+		funcStr = strings.ToUpper(match[2])
+		if !aggregateFuncs[funcStr] {
+			return "", fmt.Errorf("database: unknown operator or function [%s]", match[2])
+		}
+	}
+	columnStr := "*"
+	if column != "*" {
+		columnStr = m.FormatColumn(column)
+		if table != "" {
+			columnStr = m.FormatColumn(table) + "." + columnStr
+		}
+	}
+	if path != "" {
+		columnStr = m.Dialect().JSONPath(columnStr, path)
+	}
+	if funcStr != "" {
+		columnStr = fmt.Sprintf("%s(%s)", funcStr, columnStr)
+	}
+	return columnStr, nil
+}
+
+// 解析字段，未指定操作符时为=
+// 方括号中不是操作符时视为字段的一部分，如 id[count]
+func (m *SqlDB) explainColumn(column string) *DBColumn {
+	match := columnReg.FindStringSubmatch(column)
+	filed := &DBColumn{Field: column, Icon: "="}
+	if len(match) > 0 {
+		icon := strings.ToLower(match[2])
+		if _, ok := whereOperators[icon]; ok || updateOperators[icon] {
+			filed.Field = match[1]
+			filed.Icon = icon
+		}
+	}
+	return filed
+}
+
+// 将切片转换为[]interface{}，[]byte不视为切片
+func sliceValues(v interface{}) ([]interface{}, bool) {
+	if list, ok := v.([]interface{}); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		list = append(list, rv.Index(i).Interface())
+	}
+	return list, true
+}
+
+// JSON条件的值，字符串视为已编码的JSON
+func jsonValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string, []byte:
+		return val, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
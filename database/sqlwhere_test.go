@@ -0,0 +1,64 @@
+package database
+
+import (
+	"go_lib/utils"
+	"reflect"
+	"testing"
+)
+
+// 测试条件操作符
+func TestSqlDB_BuildWhere(t *testing.T) {
+	db := &SqlDB{dialect: MysqlDialect{}}
+	tests := []struct {
+		where  utils.M
+		expect string
+		values []interface{}
+	}{
+		{utils.M{"age[<>]": []int{18, 30}}, "(`u`.`age` BETWEEN ? AND ?)", []interface{}{18, 30}},
+		{utils.M{"age[!<>]": []interface{}{18, 30}}, "(`u`.`age` NOT BETWEEN ? AND ?)", []interface{}{18, 30}},
+		{utils.M{"name[!like]": "张%"}, "(`u`.`name` NOT LIKE ?)", []interface{}{"张%"}},
+		{utils.M{"name[regexp]": "^a"}, "(`u`.`name` REGEXP ?)", []interface{}{"^a"}},
+		{utils.M{"deleted_at": nil}, "(`u`.`deleted_at` IS NULL)", nil},
+		{utils.M{"deleted_at[!]": nil}, "(`u`.`deleted_at` IS NOT NULL)", nil},
+		{utils.M{"id": []int64{1, 2}}, "(`u`.`id` IN (?,?))", []interface{}{int64(1), int64(2)}},
+		{utils.M{"id": []int{}}, "((1 = 0))", nil},
+		{utils.M{"tags[json]": []string{"vip"}}, "(JSON_CONTAINS(`u`.`tags`, ?))", []interface{}{`["vip"]`}},
+		{utils.M{"attrs->>size.width[>]": 10}, "(`u`.`attrs`->>'$.size.width' > ?)", []interface{}{10}},
+	}
+	for _, test := range tests {
+		str, values, err := db.buildWhere(test.where, "AND", "u")
+		if err != nil {
+			t.Errorf("%v: %v", test.where, err)
+			continue
+		}
+		if str != test.expect || !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%v: %s %v", test.where, str, values)
+		}
+	}
+
+	// 不合法的条件
+	invalid := []utils.M{
+		{"age[<>]": 18},
+		{"age[>]": nil},
+		{"age[+]": 1},
+		{"age[drop]": 1},
+		{"age[>]": []int{1, 2}},
+		{"attrs->>a';--": 1},
+	}
+	for _, where := range invalid {
+		if str, _, err := db.buildWhere(where, "AND", "u"); err == nil {
+			t.Errorf("%v should be rejected, got %s", where, str)
+		}
+	}
+
+	// PostgreSQL
+	pg := &SqlDB{dialect: PostgresDialect{}}
+	str, _, _ := pg.buildWhere(utils.M{"attrs->>tags[0]": "a"}, "AND", "u")
+	if str != `("u"."attrs"#>>'{tags,0}' = ?)` {
+		t.Errorf("unexpected where: %s", str)
+	}
+	str, _, _ = pg.buildWhere(utils.M{"name[!regexp]": "^a"}, "AND", "u")
+	if str != `("u"."name" !~ ?)` {
+		t.Errorf("unexpected where: %s", str)
+	}
+}
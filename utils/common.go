@@ -51,6 +51,28 @@ func Under2Hump(str string) string {
 	return strings.Join(strMap, "")
 }
 
+// 驼峰转下划线
+func Hump2Under(str string) string {
+	runes := []rune(str)
+	var buf strings.Builder
+	for i, c := range runes {
+		if c >= 'A' && c <= 'Z' {
+			// 连续大写视为一个单词，如UserID => user_id
+			if i > 0 && runes[i-1] != '_' && (isLower(runes[i-1]) || (i+1 < len(runes) && isLower(runes[i+1]))) {
+				buf.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// 是否小写字母或数字
+func isLower(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
 // 首字母大写
 func UcFirst(str string) string {
 	first := str[0:1]
@@ -29,3 +29,17 @@ func TestStruct2Map(t *testing.T) {
 	fmt.Println(str)
 
 }
+
+func TestHump2Under(t *testing.T) {
+	tests := map[string]string{
+		"UserName":   "user_name",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+		"age":        "age",
+	}
+	for in, expect := range tests {
+		if res := Hump2Under(in); res != expect {
+			t.Errorf("Hump2Under(%s) = %s, expect %s", in, res, expect)
+		}
+	}
+}